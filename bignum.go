@@ -0,0 +1,230 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"math/big"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxFuzzBignums is the max number of tag 2 and 3 items in data checked by fuzzBignum.
+const maxFuzzBignums = 8
+
+var (
+	dmBigIntDecodePointer, _               = cbor.DecOptions{BigIntDec: cbor.BigIntDecodePointer}.DecMode()
+	dmBigIntDecodeValueSignedOrBigInt, _   = cbor.DecOptions{BigIntDec: cbor.BigIntDecodeValue, IntDec: cbor.IntDecConvertSignedOrBigInt}.DecMode()
+	dmBigIntDecodePointerSignedOrBigInt, _ = cbor.DecOptions{BigIntDec: cbor.BigIntDecodePointer, IntDec: cbor.IntDecConvertSignedOrBigInt}.DecMode()
+)
+
+// bigIntDecModes are decoding modes with every BigIntDec option.  IntDecConvertSignedOrBigInt
+// makes CBOR integers overflowing int64 decode to big.Int in interface{}.
+var bigIntDecModes = []struct {
+	bigIntDec cbor.BigIntDecMode
+	intDec    cbor.IntDecMode
+	dm        cbor.DecMode
+}{
+	{cbor.BigIntDecodeValue, cbor.IntDecConvertNone, dmDefault},
+	{cbor.BigIntDecodePointer, cbor.IntDecConvertNone, dmBigIntDecodePointer},
+	{cbor.BigIntDecodeValue, cbor.IntDecConvertSignedOrBigInt, dmBigIntDecodeValueSignedOrBigInt},
+	{cbor.BigIntDecodePointer, cbor.IntDecConvertSignedOrBigInt, dmBigIntDecodePointerSignedOrBigInt},
+}
+
+// fuzzBignum decodes tag 2 and 3 items in item to big.Int, *big.Int, []byte, Go integer
+// types, and interface{} with every BigIntDec option, and compares results with bignumModel.
+// CBOR integers are decoded to interface{} with every BigIntDec option.
+func fuzzBignum(item *dataItem) {
+	n := 0
+	item.walk(func(it *dataItem) {
+		if n >= maxFuzzBignums {
+			return
+		}
+		switch {
+		case it.major == majorTypePositiveInt || it.major == majorTypeNegativeInt:
+			n++
+			fuzzIntegerBigIntDec(it)
+			return
+		case it.major != majorTypeTag || (it.val != 2 && it.val != 3) || it.tagContent().major != majorTypeByteString:
+			return
+		}
+		n++
+
+		neg, mag := bignumModel(it)
+		for _, mode := range bigIntDecModes {
+			// BigIntDec option only affects decoding to interface{}.
+			var bi big.Int
+			if err := mode.dm.Unmarshal(it.raw, &bi); err != nil {
+				panic(fmt.Sprintf("decoding bignum 0x%x to big.Int returned %v", it.raw, err))
+			}
+			checkBignum(it.raw, "big.Int", &bi, neg, mag)
+
+			var pbi *big.Int
+			if err := mode.dm.Unmarshal(it.raw, &pbi); err != nil {
+				panic(fmt.Sprintf("decoding bignum 0x%x to *big.Int returned %v", it.raw, err))
+			}
+			checkBignum(it.raw, "*big.Int", pbi, neg, mag)
+
+			// Bignum content is decoded to []byte as is, including leading zeros.
+			var b []byte
+			if err := mode.dm.Unmarshal(it.raw, &b); err != nil {
+				panic(fmt.Sprintf("decoding bignum 0x%x to []byte returned %v", it.raw, err))
+			}
+			if !bytes.Equal(b, it.tagContent().content) {
+				panic(fmt.Sprintf("decoding bignum 0x%x to []byte returned 0x%x, want 0x%x", it.raw, b, it.tagContent().content))
+			}
+
+			for _, t := range integerTypes {
+				want, fits := bignumIntegerModel(neg, mag, t)
+				for _, pt := range []reflect.Type{t, reflect.PtrTo(t)} {
+					v := reflect.New(pt)
+					err := mode.dm.Unmarshal(it.raw, v.Interface())
+					if !fits {
+						if _, ok := err.(*cbor.UnmarshalTypeError); !ok {
+							panic(fmt.Sprintf("decoding bignum 0x%x to %s returned %v, want UnmarshalTypeError", it.raw, pt, err))
+						}
+						continue
+					}
+					if err != nil {
+						panic(fmt.Sprintf("decoding bignum 0x%x to %s returned %v", it.raw, pt, err))
+					}
+					got := v.Elem()
+					if pt.Kind() == reflect.Ptr {
+						got = v.Elem().Elem()
+					}
+					if !DeepEqual(got.Interface(), want.Interface()) {
+						panic(fmt.Sprintf("decoding bignum 0x%x to %s returned %v, want %v", it.raw, pt, got, want))
+					}
+				}
+			}
+
+			// Bignum is decoded to big.Int or *big.Int in interface{}, even if it fits Go integer.
+			var iv interface{}
+			if err := mode.dm.Unmarshal(it.raw, &iv); err != nil {
+				panic(fmt.Sprintf("decoding bignum 0x%x to interface{} with BigIntDec %d returned %v", it.raw, mode.bigIntDec, err))
+			}
+			checkBigIntDec(it.raw, iv, mode.bigIntDec, func(bi *big.Int) {
+				checkBignum(it.raw, "interface{}", bi, neg, mag)
+			})
+		}
+	})
+}
+
+// fuzzIntegerBigIntDec decodes integer item to interface{} with every BigIntDec option, and
+// compares result with interfaceIntegerModel.
+func fuzzIntegerBigIntDec(item *dataItem) {
+	bi := item.bigInt()
+	for _, mode := range bigIntDecModes {
+		var v interface{}
+		err := mode.dm.Unmarshal(item.raw, &v)
+		if err != nil {
+			panic(fmt.Sprintf("decoding 0x%x (%s) to interface{} with BigIntDec %d, IntDec %d returned %v", item.raw, bi, mode.bigIntDec, mode.intDec, err))
+		}
+		want, _ := interfaceIntegerModel(bi, item.major, mode.intDec)
+		if _, isBigInt := want.(big.Int); !isBigInt {
+			if !DeepEqual(v, want) {
+				panic(fmt.Sprintf("decoding 0x%x (%s) to interface{} with BigIntDec %d, IntDec %d returned %v (%T), want %v (%T)", item.raw, bi, mode.bigIntDec, mode.intDec, v, v, want, want))
+			}
+			continue
+		}
+		checkBigIntDec(item.raw, v, mode.bigIntDec, func(got *big.Int) {
+			if got.Cmp(bi) != 0 {
+				panic(fmt.Sprintf("decoding 0x%x to interface{} with BigIntDec %d, IntDec %d returned %s, want %s", item.raw, mode.bigIntDec, mode.intDec, got, bi))
+			}
+		})
+	}
+}
+
+// checkBigIntDec checks that v decoded to interface{} is big.Int with BigIntDecodeValue, or
+// non-nil *big.Int with BigIntDecodePointer, and calls check with its value.
+func checkBigIntDec(data []byte, v interface{}, bigIntDec cbor.BigIntDecMode, check func(*big.Int)) {
+	switch x := v.(type) {
+	case big.Int:
+		if bigIntDec == cbor.BigIntDecodeValue {
+			check(&x)
+			return
+		}
+	case *big.Int:
+		if bigIntDec == cbor.BigIntDecodePointer && x != nil {
+			check(x)
+			return
+		}
+	}
+	panic(fmt.Sprintf("decoding 0x%x to interface{} with BigIntDec %d returned %v (%T)", data, bigIntDec, v, v))
+}
+
+// checkBignum checks that bi has sign neg and minimal big-endian magnitude mag.
+func checkBignum(data []byte, typ string, bi *big.Int, neg bool, mag []byte) {
+	if bi == nil || (bi.Sign() < 0) != neg || !bytes.Equal(bi.Bytes(), mag) {
+		sign := ""
+		if neg {
+			sign = "-"
+		}
+		panic(fmt.Sprintf("decoding bignum 0x%x to %s returned %v, want %s0x%x", data, typ, bi, sign, mag))
+	}
+}
+
+// bignumModel returns sign and minimal big-endian magnitude of tag 2 or 3 item, computed
+// from byte string content without math/big.  Tag 3 content n represents -1-n
+// (RFC 8949 section 3.4.3), so its magnitude is n+1.
+func bignumModel(item *dataItem) (bool, []byte) {
+	content := item.tagContent().content
+	for len(content) > 0 && content[0] == 0 {
+		content = content[1:]
+	}
+	if item.val == 2 {
+		return false, content
+	}
+
+	mag := make([]byte, len(content)+1)
+	copy(mag[1:], content)
+	for i := len(mag) - 1; i >= 0; i-- {
+		mag[i]++
+		if mag[i] != 0 {
+			break
+		}
+	}
+	if mag[0] == 0 {
+		mag = mag[1:]
+	}
+	return true, mag
+}
+
+// bignumIntegerModel returns bignum with sign neg and magnitude mag as Go integer type t,
+// and false if it overflows t.
+func bignumIntegerModel(neg bool, mag []byte, t reflect.Type) (reflect.Value, bool) {
+	v := reflect.New(t).Elem()
+	if len(mag) > 8 {
+		return v, false
+	}
+	var u uint64
+	for _, b := range mag {
+		u = u<<8 | uint64(b)
+	}
+
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if neg || v.OverflowUint(u) {
+			return v, false
+		}
+		v.SetUint(u)
+	default:
+		if neg {
+			// Magnitude of math.MinInt64 is 1<<63.
+			if u > 1<<63 || v.OverflowInt(int64(-u)) {
+				return v, false
+			}
+			v.SetInt(int64(-u))
+			break
+		}
+		if u > math.MaxInt64 || v.OverflowInt(int64(u)) {
+			return v, false
+		}
+		v.SetInt(int64(u))
+	}
+	return v, true
+}
@@ -0,0 +1,209 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE message structures (RFC 9052 sections 4-6).  Each message is registered with its
+// CBOR tag number in coseTags.
+type (
+	// coseProtectedHeader is a header map wrapped in byte string.  Empty byte string is
+	// empty header map.
+	coseProtectedHeader struct {
+		coseHeader
+		raw []byte // encoded header map, kept as is because it's signed or authenticated
+	}
+	coseSign1 struct {
+		_           struct{} `cbor:",toarray"`
+		Protected   coseProtectedHeader
+		Unprotected coseHeader
+		Payload     []byte
+		Signature   []byte
+	}
+	coseSignature struct {
+		_           struct{} `cbor:",toarray"`
+		Protected   coseProtectedHeader
+		Unprotected coseHeader
+		Signature   []byte
+	}
+	coseSign struct {
+		_           struct{} `cbor:",toarray"`
+		Protected   coseProtectedHeader
+		Unprotected coseHeader
+		Payload     []byte
+		Signatures  []coseSignature
+	}
+	coseMac0 struct {
+		_           struct{} `cbor:",toarray"`
+		Protected   coseProtectedHeader
+		Unprotected coseHeader
+		Payload     []byte
+		Tag         []byte
+	}
+	// coseRecipient is decoded from CBOR array of 3 elements, or 4 elements with nested
+	// recipients.
+	coseRecipient struct {
+		Protected   coseProtectedHeader
+		Unprotected coseHeader
+		Ciphertext  []byte
+		Recipients  []coseRecipient
+	}
+	coseMac struct {
+		_           struct{} `cbor:",toarray"`
+		Protected   coseProtectedHeader
+		Unprotected coseHeader
+		Payload     []byte
+		Tag         []byte
+		Recipients  []coseRecipient
+	}
+	coseEncrypt0 struct {
+		_           struct{} `cbor:",toarray"`
+		Protected   coseProtectedHeader
+		Unprotected coseHeader
+		Ciphertext  []byte
+	}
+	coseEncrypt struct {
+		_           struct{} `cbor:",toarray"`
+		Protected   coseProtectedHeader
+		Unprotected coseHeader
+		Ciphertext  []byte
+		Recipients  []coseRecipient
+	}
+)
+
+var errCOSERecipient = errors.New("cose: COSE_recipient must have 3 or 4 elements")
+
+func (h coseProtectedHeader) MarshalCBOR() ([]byte, error) {
+	if h.raw != nil {
+		return cbor.Marshal(h.raw)
+	}
+	b, err := cbor.Marshal(h.coseHeader)
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(b, []byte{0xa0}) {
+		// Empty header map is encoded as empty byte string.
+		b = []byte{}
+	}
+	return cbor.Marshal(b)
+}
+
+func (h *coseProtectedHeader) UnmarshalCBOR(data []byte) error {
+	var b []byte
+	if err := cbor.Unmarshal(data, &b); err != nil {
+		return err
+	}
+	*h = coseProtectedHeader{raw: b}
+	if len(b) == 0 {
+		return nil
+	}
+	return cbor.Unmarshal(b, &h.coseHeader)
+}
+
+func (r coseRecipient) MarshalCBOR() ([]byte, error) {
+	a := []interface{}{r.Protected, r.Unprotected, r.Ciphertext}
+	if r.Recipients != nil {
+		a = append(a, r.Recipients)
+	}
+	return cbor.Marshal(a)
+}
+
+func (r *coseRecipient) UnmarshalCBOR(data []byte) error {
+	var a []cbor.RawMessage
+	if err := cbor.Unmarshal(data, &a); err != nil {
+		return err
+	}
+	if len(a) != 3 && len(a) != 4 {
+		return errCOSERecipient
+	}
+	*r = coseRecipient{}
+	for i, v := range []interface{}{&r.Protected, &r.Unprotected, &r.Ciphertext, &r.Recipients}[:len(a)] {
+		if err := cbor.Unmarshal(a[i], v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// coseMessageTags are COSE message types and their tag numbers.
+var coseMessageTags = []struct {
+	t   reflect.Type
+	num uint64
+}{
+	{reflect.TypeOf(coseSign1{}), 18},
+	{reflect.TypeOf(coseSign{}), 98},
+	{reflect.TypeOf(coseMac0{}), 17},
+	{reflect.TypeOf(coseMac{}), 97},
+	{reflect.TypeOf(coseEncrypt0{}), 16},
+	{reflect.TypeOf(coseEncrypt{}), 96},
+}
+
+var coseTags = func() cbor.TagSet {
+	tags := cbor.NewTagSet()
+	for _, m := range coseMessageTags {
+		if err := tags.Add(cbor.TagOptions{EncTag: cbor.EncTagRequired, DecTag: cbor.DecTagRequired}, m.t, m.num); err != nil {
+			panic(err)
+		}
+	}
+	return tags
+}()
+
+var (
+	dmCOSE, _ = cbor.DecOptions{}.DecModeWithTags(coseTags)
+	emCOSE, _ = cbor.EncOptions{}.EncModeWithTags(coseTags)
+)
+
+// fuzzCOSE decodes data to each COSE message type with registered tag numbers, and checks
+// that decoded message has the required tag number, and that it round trips with the
+// same tag number and protected header bytes.
+func fuzzCOSE(data []byte, item *dataItem) {
+	for _, m := range coseMessageTags {
+		v := reflect.New(m.t)
+		if err := dmCOSE.Unmarshal(data, v.Interface()); err != nil {
+			continue
+		}
+
+		// Self-described CBOR tag is skipped before registered tag number.
+		msg := item
+		for msg.major == majorTypeTag && msg.val == 55799 {
+			msg = msg.tagContent()
+		}
+		if msg.major != majorTypeTag || msg.val != m.num || msg.tagContent().major == majorTypeTag {
+			panic(fmt.Sprintf("decoded CBOR data 0x%x without tag number %d to %s", data, m.num, m.t))
+		}
+
+		b, err := emCOSE.Marshal(v.Interface())
+		if err != nil {
+			panic(err)
+		}
+		encoded, _, err := parseDataItem(b)
+		if err != nil {
+			panic(fmt.Sprintf("encoding %s produced malformed CBOR data 0x%x: %v", m.t, b, err))
+		}
+		if encoded.major != majorTypeTag || encoded.val != m.num {
+			panic(fmt.Sprintf("encoding %s produced 0x%x without tag number %d", m.t, b, m.num))
+		}
+		if content := msg.tagContent(); content.major == majorTypeArray {
+			p1, p2 := content.items[0], encoded.tagContent().items[0]
+			if p1.major == majorTypeByteString && !bytes.Equal(p1.content, p2.content) {
+				panic(fmt.Sprintf("encoding %s changed protected header 0x%x to 0x%x", m.t, p1.content, p2.content))
+			}
+		}
+
+		v2 := reflect.New(m.t)
+		if err := dmCOSE.Unmarshal(b, v2.Interface()); err != nil {
+			panic(fmt.Sprintf("decoding 0x%x to %s returned %v", b, m.t, err))
+		}
+		if !DeepEqual(v.Interface(), v2.Interface()) {
+			panic(fmt.Sprintf("not equal: v1 %v, v2 %v (%s)", v.Elem(), v2.Elem(), m.t))
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// coseAlgAESCCM16_64_128 is AES-CCM with 128-bit key, 64-bit tag, and 13-byte nonce
+// (RFC 9053 section 4.2).
+const coseAlgAESCCM16_64_128 = 10
+
+// AES-CCM parameters of coseAlgAESCCM16_64_128 (RFC 3610 section 2).
+const (
+	ccmTagSize   = 8
+	ccmNonceSize = 13
+	ccmLenSize   = 15 - ccmNonceSize
+)
+
+var (
+	errCOSEUnknownKey = errors.New("cose: COSE message key is unknown")
+	errCOSEAlg        = errors.New("cose: COSE algorithm is unsupported")
+	errCOSEIV         = errors.New("cose: COSE IV has wrong length")
+	errCCMLength      = errors.New("cose: AES-CCM ciphertext has wrong length")
+	errCCMAuth        = errors.New("cose: AES-CCM authentication failed")
+)
+
+// knownCWTClaims are claims of CWT examples in RFC 8392 Appendix A.1.
+var knownCWTClaims = func() claims {
+	data, err := hex.DecodeString("a70175636f61703a2f2f61732e6578616d706c652e636f6d02656572696b77037818636f61703a2f2f6c696768742e6578616d706c652e636f6d041a5612aeb0051a5610d9f0061a5610d9f007420b71")
+	if err != nil {
+		panic(err)
+	}
+	var c claims
+	if err := cbor.Unmarshal(data, &c); err != nil {
+		panic(err)
+	}
+	return c
+}()
+
+// fuzzCOSEDecrypt decrypts COSE_Encrypt0 in item encrypted with a known key, and checks that
+// plaintext is decoded to known claims before and after message is decoded and encoded again.
+// Plaintext of nested CWT is decoded as CWT.
+func fuzzCOSEDecrypt(item *dataItem, level int) {
+	if level > maxCWTNestedLevel {
+		return
+	}
+	for item.major == majorTypeTag && (item.val == 55799 || item.val == cwtTagNum) {
+		item = item.tagContent()
+	}
+	if item.major != majorTypeTag || item.val != 16 {
+		return
+	}
+
+	var m coseEncrypt0
+	if dmCOSE.Unmarshal(item.raw, &m) != nil {
+		return
+	}
+	plaintext, err := decryptCOSEEncrypt0(&m)
+	if err != nil {
+		return
+	}
+	checkDecryptedCWT(item.raw, plaintext, m.Protected.ContentType, level)
+
+	b, err := emCOSE.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	var m2 coseEncrypt0
+	if err := dmCOSE.Unmarshal(b, &m2); err != nil {
+		panic(fmt.Sprintf("decoding COSE_Encrypt0 0x%x returned %v", b, err))
+	}
+	plaintext2, err := decryptCOSEEncrypt0(&m2)
+	if err != nil {
+		panic(fmt.Sprintf("decrypting COSE_Encrypt0 0x%x failed after re-encoding it to 0x%x: %v", item.raw, b, err))
+	}
+	checkDecryptedCWT(b, plaintext2, m2.Protected.ContentType, level)
+}
+
+// checkDecryptedCWT checks that plaintext decrypted from data is known claims, or nested CWT
+// with known claims as payload.
+func checkDecryptedCWT(data []byte, plaintext []byte, contentType interface{}, level int) {
+	if level > maxCWTNestedLevel {
+		return
+	}
+	// Authenticated plaintext isn't mutated, so it must be decoded.
+	nested, _, err := parseDataItem(plaintext)
+	if err != nil {
+		panic(fmt.Sprintf("decrypting COSE_Encrypt0 0x%x produced malformed CBOR data 0x%x: %v", data, plaintext, err))
+	}
+	if isCWTContentType(contentType) || nested.major == majorTypeTag {
+		for nested.major == majorTypeTag && (nested.val == 55799 || nested.val == cwtTagNum) {
+			nested = nested.tagContent()
+		}
+		fuzzCOSEVerify(nested)
+		if nested.major == majorTypeTag && nested.val == 16 {
+			fuzzCOSEDecrypt(nested, level+1)
+			return
+		}
+		for _, m := range coseMessageTags {
+			if nested.major != majorTypeTag || m.num != nested.val {
+				continue
+			}
+			v := reflect.New(m.t)
+			if err := dmCOSE.Unmarshal(nested.raw, v.Interface()); err != nil {
+				panic(fmt.Sprintf("decoding nested CWT 0x%x decrypted from 0x%x to %s returned %v", nested.raw, data, m.t, err))
+			}
+			if payload := v.Elem().FieldByName("Payload"); payload.IsValid() {
+				protected := v.Elem().FieldByName("Protected").Interface().(coseProtectedHeader)
+				checkDecryptedCWT(data, payload.Bytes(), protected.ContentType, level+1)
+			}
+			return
+		}
+		panic(fmt.Sprintf("decrypting COSE_Encrypt0 0x%x produced 0x%x, want nested CWT", data, plaintext))
+	}
+
+	var c claims
+	if err := cbor.Unmarshal(plaintext, &c); err != nil {
+		panic(fmt.Sprintf("decoding claims 0x%x decrypted from 0x%x returned %v", plaintext, data, err))
+	}
+	if !DeepEqual(c, knownCWTClaims) {
+		panic(fmt.Sprintf("decrypting COSE_Encrypt0 0x%x produced claims %+v, want %+v", data, c, knownCWTClaims))
+	}
+}
+
+// decryptCOSEEncrypt0 returns plaintext of m encrypted with AES-CCM-16-64-128 and a known key.
+func decryptCOSEEncrypt0(m *coseEncrypt0) ([]byte, error) {
+	key, ok := coseMessageKey(m.Protected, m.Unprotected).(symmetricKey)
+	if !ok || len(key.K) != 16 {
+		return nil, errCOSEUnknownKey
+	}
+	if m.Protected.Alg != coseAlgAESCCM16_64_128 {
+		return nil, errCOSEAlg
+	}
+	iv := m.Protected.IV
+	if iv == nil {
+		iv = m.Unprotected.IV
+	}
+	if len(iv) != ccmNonceSize {
+		return nil, errCOSEIV
+	}
+	// Enc_structure (RFC 9052 section 5.3)
+	aad, err := emCoreDeterministic.Marshal([]interface{}{"Encrypt0", protectedBytes(m.Protected), []byte{}})
+	if err != nil {
+		panic(err)
+	}
+	block, err := aes.NewCipher(key.K.Bytes())
+	if err != nil {
+		panic(err)
+	}
+	return ccmOpen(block, iv, m.Ciphertext, aad)
+}
+
+// ccmOpen decrypts and authenticates ciphertext with AES-CCM (RFC 3610), with 8-byte tag and
+// 2-byte length field.
+func ccmOpen(block cipher.Block, nonce, ciphertext, aad []byte) ([]byte, error) {
+	if len(ciphertext) < ccmTagSize || len(ciphertext)-ccmTagSize >= 1<<(8*ccmLenSize) {
+		return nil, errCCMLength
+	}
+	n := len(ciphertext) - ccmTagSize
+
+	// CTR mode with counter block A_i = flags | nonce | i.  S_0 encrypts the tag.
+	var ctr [aes.BlockSize]byte
+	ctr[0] = ccmLenSize - 1
+	copy(ctr[1:], nonce)
+	s0 := make([]byte, aes.BlockSize)
+	block.Encrypt(s0, ctr[:])
+	ctr[aes.BlockSize-1] = 1
+	plaintext := make([]byte, n)
+	cipher.NewCTR(block, ctr[:]).XORKeyStream(plaintext, ciphertext[:n])
+
+	// CBC-MAC over B_0, encoded AAD, and plaintext, each padded to block size.
+	var b0 [aes.BlockSize]byte
+	b0[0] = byte((ccmTagSize-2)/2)<<3 | (ccmLenSize - 1)
+	if len(aad) > 0 {
+		b0[0] |= 1 << 6
+	}
+	copy(b0[1:], nonce)
+	binary.BigEndian.PutUint16(b0[aes.BlockSize-ccmLenSize:], uint16(n))
+	macInput := append([]byte{}, b0[:]...)
+	if len(aad) > 0 {
+		if len(aad) < 1<<16-1<<8 {
+			macInput = append(macInput, byte(len(aad)>>8), byte(len(aad)))
+		} else {
+			macInput = append(macInput, 0xff, 0xfe, byte(len(aad)>>24), byte(len(aad)>>16), byte(len(aad)>>8), byte(len(aad)))
+		}
+		macInput = ccmPad(append(macInput, aad...))
+	}
+	macInput = ccmPad(append(macInput, plaintext...))
+	mac := make([]byte, aes.BlockSize)
+	for i := 0; i < len(macInput); i += aes.BlockSize {
+		for j := range mac {
+			mac[j] ^= macInput[i+j]
+		}
+		block.Encrypt(mac, mac)
+	}
+
+	tag := make([]byte, ccmTagSize)
+	for i := range tag {
+		tag[i] = mac[i] ^ s0[i]
+	}
+	if subtle.ConstantTimeCompare(tag, ciphertext[n:]) != 1 {
+		return nil, errCCMAuth
+	}
+	return plaintext, nil
+}
+
+// ccmPad pads b with zeros to multiple of AES block size.
+func ccmPad(b []byte) []byte {
+	if r := len(b) % aes.BlockSize; r != 0 {
+		b = append(b, make([]byte, aes.BlockSize-r)...)
+	}
+	return b
+}
@@ -0,0 +1,219 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE_Key types picked by kty (RFC 9053 section 7, RFC 8230 section 4).  Parameters are
+// typed so that decoding rejects parameter values of wrong CBOR type.
+type (
+	okpKey struct {
+		Kty    int             `cbor:"1,keyasint"`
+		Kid    cbor.ByteString `cbor:"2,keyasint,omitempty"`
+		Alg    int             `cbor:"3,keyasint,omitempty"`
+		KeyOps []interface{}   `cbor:"4,keyasint,omitempty"`
+		BaseIV cbor.ByteString `cbor:"5,keyasint,omitempty"`
+		Crv    int             `cbor:"-1,keyasint"`
+		X      cbor.ByteString `cbor:"-2,keyasint,omitempty"`
+		D      cbor.ByteString `cbor:"-4,keyasint,omitempty"`
+	}
+	ec2Key struct {
+		Kty    int             `cbor:"1,keyasint"`
+		Kid    cbor.ByteString `cbor:"2,keyasint,omitempty"`
+		Alg    int             `cbor:"3,keyasint,omitempty"`
+		KeyOps []interface{}   `cbor:"4,keyasint,omitempty"`
+		BaseIV cbor.ByteString `cbor:"5,keyasint,omitempty"`
+		Crv    int             `cbor:"-1,keyasint"`
+		X      cbor.ByteString `cbor:"-2,keyasint,omitempty"`
+		Y      ec2Y            `cbor:"-3,keyasint,omitempty"`
+		D      cbor.ByteString `cbor:"-4,keyasint,omitempty"`
+	}
+	rsaKey struct {
+		Kty    int             `cbor:"1,keyasint"`
+		Kid    cbor.ByteString `cbor:"2,keyasint,omitempty"`
+		Alg    int             `cbor:"3,keyasint,omitempty"`
+		KeyOps []interface{}   `cbor:"4,keyasint,omitempty"`
+		BaseIV cbor.ByteString `cbor:"5,keyasint,omitempty"`
+		N      cbor.ByteString `cbor:"-1,keyasint"`
+		E      cbor.ByteString `cbor:"-2,keyasint"`
+		D      cbor.ByteString `cbor:"-3,keyasint,omitempty"`
+		P      cbor.ByteString `cbor:"-4,keyasint,omitempty"`
+		Q      cbor.ByteString `cbor:"-5,keyasint,omitempty"`
+		DP     cbor.ByteString `cbor:"-6,keyasint,omitempty"`
+		DQ     cbor.ByteString `cbor:"-7,keyasint,omitempty"`
+		QInv   cbor.ByteString `cbor:"-8,keyasint,omitempty"`
+		Other  []interface{}   `cbor:"-9,keyasint,omitempty"`
+		Ri     cbor.ByteString `cbor:"-10,keyasint,omitempty"`
+		Di     cbor.ByteString `cbor:"-11,keyasint,omitempty"`
+		Ti     cbor.ByteString `cbor:"-12,keyasint,omitempty"`
+	}
+	symmetricKey struct {
+		Kty    int             `cbor:"1,keyasint"`
+		Kid    cbor.ByteString `cbor:"2,keyasint,omitempty"`
+		Alg    int             `cbor:"3,keyasint,omitempty"`
+		KeyOps []interface{}   `cbor:"4,keyasint,omitempty"`
+		BaseIV cbor.ByteString `cbor:"5,keyasint,omitempty"`
+		K      cbor.ByteString `cbor:"-1,keyasint"`
+	}
+
+	// ec2Y is y-coordinate, or sign bit of y-coordinate for point compression.
+	ec2Y struct {
+		v interface{} // []byte or bool
+	}
+)
+
+func (y ec2Y) MarshalCBOR() ([]byte, error) {
+	return cbor.Marshal(y.v)
+}
+
+func (y *ec2Y) UnmarshalCBOR(data []byte) error {
+	var v interface{}
+	if err := cbor.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch v.(type) {
+	case nil, []byte, bool:
+		y.v = v
+		return nil
+	}
+	return &cbor.UnmarshalTypeError{CBORType: fmt.Sprintf("major type %d", data[0]>>5), GoType: "ec2Y"}
+}
+
+// COSE_Key parameter types.
+const (
+	paramInt = iota
+	paramByteString
+	paramArray
+	paramByteStringOrBool
+)
+
+// coseKeyParams are parameter types by label for each kty.  Labels 1-5 are common
+// parameters (RFC 9052 section 7.1).
+var coseKeyParams = map[int]map[int64]int{
+	1: {-1: paramInt, -2: paramByteString, -4: paramByteString},
+	2: {-1: paramInt, -2: paramByteString, -3: paramByteStringOrBool, -4: paramByteString},
+	3: {
+		-1: paramByteString, -2: paramByteString, -3: paramByteString, -4: paramByteString,
+		-5: paramByteString, -6: paramByteString, -7: paramByteString, -8: paramByteString,
+		-9: paramArray, -10: paramByteString, -11: paramByteString, -12: paramByteString,
+	},
+	4: {-1: paramByteString},
+}
+
+var coseKeyCommonParams = map[int64]int{1: paramInt, 2: paramByteString, 3: paramInt, 4: paramArray, 5: paramByteString}
+
+var coseKeyTypes = map[int]reflect.Type{
+	1: reflect.TypeOf(okpKey{}),
+	2: reflect.TypeOf(ec2Key{}),
+	3: reflect.TypeOf(rsaKey{}),
+	4: reflect.TypeOf(symmetricKey{}),
+}
+
+// fuzzCOSEKey decodes COSE_Key data to key type picked by kty with ExtraDecErrorUnknownField,
+// and checks that decoding fails exactly when a parameter is unknown or has wrong CBOR type.
+func fuzzCOSEKey(data []byte, item *dataItem, kty int) {
+	t, ok := coseKeyTypes[kty]
+	if !ok {
+		return
+	}
+	// Nested values that can't be decoded make parameter types irrelevant.
+	var iv interface{}
+	if cbor.Unmarshal(data, &iv) != nil {
+		return
+	}
+	for item.major == majorTypeTag {
+		item = item.tagContent()
+	}
+	if item.major != majorTypeMap {
+		return
+	}
+
+	v := reflect.New(t)
+	err := dmExtraErrorUnknownField.Unmarshal(data, v.Interface())
+
+	flds, _ := structFields(t)
+	if index := unknownField(flds, item); index >= 0 {
+		if e, ok := err.(*cbor.UnknownFieldError); !ok || e.Index != index {
+			panic(fmt.Sprintf("decoding COSE_Key 0x%x to %s returned %v, want UnknownFieldError at index %d", data, t, err, index))
+		}
+		return
+	}
+
+	var wrongType *dataItem
+	for j, i := range matchStructFields(flds, item) {
+		if i < 0 {
+			continue
+		}
+		label := flds[i].nameAsInt
+		param, ok := coseKeyParams[kty][label]
+		if !ok {
+			param = coseKeyCommonParams[label]
+		}
+		valid, ok := validCOSEKeyParam(item.value(j), param)
+		if !ok {
+			return
+		}
+		if !valid && wrongType == nil {
+			wrongType = item.value(j)
+		}
+	}
+	if wrongType != nil {
+		if _, ok := err.(*cbor.UnmarshalTypeError); !ok {
+			panic(fmt.Sprintf("decoding COSE_Key 0x%x with parameter 0x%x of wrong type to %s returned %v, want UnmarshalTypeError", data, wrongType.raw, t, err))
+		}
+		return
+	}
+	if err != nil {
+		panic(fmt.Sprintf("decoding COSE_Key 0x%x to %s returned %v", data, t, err))
+	}
+
+	// Strictly decoded key round trips.
+	b, err := emCoreDeterministic.Marshal(v.Interface())
+	if err != nil {
+		panic(err)
+	}
+	v2 := reflect.New(t)
+	if err := dmExtraErrorUnknownField.Unmarshal(b, v2.Interface()); err != nil {
+		panic(fmt.Sprintf("decoding COSE_Key 0x%x to %s returned %v", b, t, err))
+	}
+	if !roundTripEqual(v.Interface(), v2.Interface()) {
+		panic(fmt.Sprintf("not equal: v1 %+v, v2 %+v (%s)", v.Elem(), v2.Elem(), t))
+	}
+}
+
+// validCOSEKeyParam returns true if parameter value item has CBOR type of param, and false
+// if result isn't specified.  Null and undefined are decoded as no-op.
+func validCOSEKeyParam(item *dataItem, param int) (valid bool, ok bool) {
+	if item.major == majorTypeTag {
+		return false, false
+	}
+	if isNull(item) {
+		return true, true
+	}
+	switch param {
+	case paramInt:
+		if item.major == majorTypePrimitives && item.ai <= 24 {
+			// Simple values, including false and true, follow the simple value model.
+			_, valid := simpleValueModel(item.val, reflect.TypeOf(int(0)))
+			return valid, true
+		}
+		if item.major != majorTypePositiveInt && item.major != majorTypeNegativeInt {
+			return false, true
+		}
+		_, fits := integerModel(item.bigInt(), reflect.TypeOf(int(0)))
+		return fits, true
+	case paramByteString:
+		return item.major == majorTypeByteString, true
+	case paramArray:
+		return item.major == majorTypeArray, true
+	case paramByteStringOrBool:
+		return item.major == majorTypeByteString || (item.major == majorTypePrimitives && (item.ai == 20 || item.ai == 21)), true
+	}
+	return false, false
+}
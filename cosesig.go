@@ -0,0 +1,164 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// COSE algorithms verified by fuzzCOSEVerify (RFC 9053 sections 2.1 and 3.1).
+const (
+	coseAlgES256       = -7
+	coseAlgHMAC256_64  = 4
+	coseAlgHMAC256_256 = 5
+)
+
+// knownCOSEKeys are COSE_Keys published in RFC 8152 Appendix C.7 and RFC 8392 Appendix A.2,
+// by kid.  COSE_Sign1, COSE_Mac0, and COSE_Encrypt0 examples in the same RFCs are verified
+// or decrypted with them.
+var knownCOSEKeys = func() map[string]interface{} {
+	keys := make(map[string]interface{})
+	for _, s := range []string{
+		// RFC 8152 C.7.1, kid "11"
+		"a501020242313120012158" + "20bac5b11cad8f99f9c72b05cf4b9e26d244dc189f745228255a219a86d6a09eff" +
+			"225820" + "20138bf82dc1b6d562be0fa54ab7804a3a64b6d72ccfed6b6fb6ed28bbfc117e",
+		// RFC 8392 A.2.1, kid "Symmetric128"
+		"a42050231f4c4d4d3051fdc2ec0a3851d5b3830104024c53796d6d6574726963313238030a",
+		// RFC 8392 A.2.2, kid "Symmetric256"
+		"a4205820403697de87af64611c1d32a05dab0fe1fcb715a86ab435f1ec99192d795693880104024c53796d6d6574726963323536030a",
+		// RFC 8392 A.2.3, kid "AsymmetricECDSA256"
+		"a72358206c1382765aec5358f117733d281c1c7bdc39884d04a45a1e6c67c858bc206c1922582060f7f1a780d8a783bfb7a2dd6b2796e8128dbbcef9d3d168db9529971a36e7b9215820143329cce7868e416927599cf65a34f3ce2ffda55a7eca69ed8919a394d42f0f2001010202524173796d6d657472696345434453413235360326",
+	} {
+		data, err := hex.DecodeString(s)
+		if err != nil {
+			panic(err)
+		}
+		var k coseKey
+		if err := cbor.Unmarshal(data, &k); err != nil {
+			panic(err)
+		}
+		v := reflect.New(coseKeyTypes[k.Kty])
+		if err := cbor.Unmarshal(data, v.Interface()); err != nil {
+			panic(err)
+		}
+		keys[string(k.Kid)] = v.Elem().Interface()
+	}
+	return keys
+}()
+
+// fuzzCOSEVerify verifies COSE_Sign1 and COSE_Mac0 in item signed or MACed with a known key,
+// and checks that verification still succeeds after message is decoded and encoded again.
+func fuzzCOSEVerify(item *dataItem) {
+	for item.major == majorTypeTag && (item.val == 55799 || item.val == cwtTagNum) {
+		item = item.tagContent()
+	}
+	if item.major != majorTypeTag || (item.val != 17 && item.val != 18) {
+		return
+	}
+
+	verify := func(data []byte) bool {
+		if item.val == 18 {
+			var m coseSign1
+			if err := dmCOSE.Unmarshal(data, &m); err != nil {
+				panic(fmt.Sprintf("decoding COSE_Sign1 0x%x returned %v", data, err))
+			}
+			return verifyCOSESign1(&m)
+		}
+		var m coseMac0
+		if err := dmCOSE.Unmarshal(data, &m); err != nil {
+			panic(fmt.Sprintf("decoding COSE_Mac0 0x%x returned %v", data, err))
+		}
+		return verifyCOSEMac0(&m)
+	}
+
+	var m interface{} = new(coseSign1)
+	if item.val == 17 {
+		m = new(coseMac0)
+	}
+	if dmCOSE.Unmarshal(item.raw, m) != nil || !verify(item.raw) {
+		return
+	}
+	b, err := emCOSE.Marshal(m)
+	if err != nil {
+		panic(err)
+	}
+	if !verify(b) {
+		panic(fmt.Sprintf("verifying COSE message 0x%x failed after re-encoding it to 0x%x", item.raw, b))
+	}
+}
+
+// verifyCOSESign1 returns true if m has valid ES256 signature made with a known key.
+func verifyCOSESign1(m *coseSign1) bool {
+	key, ok := coseMessageKey(m.Protected, m.Unprotected).(ec2Key)
+	if !ok || m.Protected.Alg != coseAlgES256 || m.Payload == nil || len(m.Signature) != 64 {
+		return false
+	}
+	// Sig_structure (RFC 9052 section 4.4)
+	tbs, err := emCoreDeterministic.Marshal([]interface{}{"Signature1", protectedBytes(m.Protected), []byte{}, m.Payload})
+	if err != nil {
+		panic(err)
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(key.X.Bytes()),
+		Y:     new(big.Int).SetBytes(key.Y.v.([]byte)),
+	}
+	digest := sha256.Sum256(tbs)
+	r := new(big.Int).SetBytes(m.Signature[:32])
+	s := new(big.Int).SetBytes(m.Signature[32:])
+	return ecdsa.Verify(pub, digest[:], r, s)
+}
+
+// verifyCOSEMac0 returns true if m has valid HMAC 256/64 or HMAC 256/256 tag made with a
+// known key.
+func verifyCOSEMac0(m *coseMac0) bool {
+	key, ok := coseMessageKey(m.Protected, m.Unprotected).(symmetricKey)
+	if !ok || m.Payload == nil {
+		return false
+	}
+	var tagLen int
+	switch m.Protected.Alg {
+	case coseAlgHMAC256_64:
+		tagLen = 8
+	case coseAlgHMAC256_256:
+		tagLen = 32
+	default:
+		return false
+	}
+	// MAC_structure (RFC 9052 section 6.3)
+	tbm, err := emCoreDeterministic.Marshal([]interface{}{"MAC0", protectedBytes(m.Protected), []byte{}, m.Payload})
+	if err != nil {
+		panic(err)
+	}
+	mac := hmac.New(sha256.New, key.K.Bytes())
+	mac.Write(tbm)
+	return hmac.Equal(mac.Sum(nil)[:tagLen], m.Tag)
+}
+
+// coseMessageKey returns known key identified by kid in protected or unprotected header.
+func coseMessageKey(protected coseProtectedHeader, unprotected coseHeader) interface{} {
+	kid := protected.Kid
+	if kid == nil {
+		kid = unprotected.Kid
+	}
+	return knownCOSEKeys[string(kid)]
+}
+
+// protectedBytes returns protected header as encoded in message, or empty byte string for
+// empty header map.
+func protectedBytes(h coseProtectedHeader) []byte {
+	if h.raw == nil {
+		return []byte{}
+	}
+	return h.raw
+}
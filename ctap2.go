@@ -0,0 +1,388 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// CTAP2 authenticator API messages (CTAP 2.1 section 6).  Message parameters have integer
+// keys, and WebAuthn entities have text string keys.
+type (
+	ctap2RPEntity struct {
+		ID   string `cbor:"id"`
+		Name string `cbor:"name,omitempty"`
+	}
+	ctap2UserEntity struct {
+		ID          []byte `cbor:"id"`
+		Name        string `cbor:"name,omitempty"`
+		DisplayName string `cbor:"displayName,omitempty"`
+	}
+	ctap2CredentialParameters struct {
+		Type string `cbor:"type"`
+		Alg  int    `cbor:"alg"`
+	}
+	ctap2CredentialDescriptor struct {
+		Type       string   `cbor:"type"`
+		ID         []byte   `cbor:"id"`
+		Transports []string `cbor:"transports,omitempty"`
+	}
+	ctap2Options struct {
+		RK bool `cbor:"rk,omitempty"`
+		UP bool `cbor:"up,omitempty"`
+		UV bool `cbor:"uv,omitempty"`
+	}
+	ctap2MakeCredentialRequest struct {
+		ClientDataHash        []byte                      `cbor:"1,keyasint"`
+		RP                    ctap2RPEntity               `cbor:"2,keyasint"`
+		User                  ctap2UserEntity             `cbor:"3,keyasint"`
+		PubKeyCredParams      []ctap2CredentialParameters `cbor:"4,keyasint"`
+		ExcludeList           []ctap2CredentialDescriptor `cbor:"5,keyasint,omitempty"`
+		Extensions            map[string]cbor.RawMessage  `cbor:"6,keyasint,omitempty"`
+		Options               *ctap2Options               `cbor:"7,keyasint,omitempty"`
+		PinUvAuthParam        []byte                      `cbor:"8,keyasint,omitempty"`
+		PinUvAuthProtocol     uint                        `cbor:"9,keyasint,omitempty"`
+		EnterpriseAttestation uint                        `cbor:"10,keyasint,omitempty"`
+	}
+	ctap2MakeCredentialResponse struct {
+		Fmt          string                     `cbor:"1,keyasint"`
+		AuthData     []byte                     `cbor:"2,keyasint"`
+		AttStmt      map[string]cbor.RawMessage `cbor:"3,keyasint"`
+		EpAtt        bool                       `cbor:"4,keyasint,omitempty"`
+		LargeBlobKey []byte                     `cbor:"5,keyasint,omitempty"`
+	}
+	ctap2GetAssertionRequest struct {
+		RPID              string                      `cbor:"1,keyasint"`
+		ClientDataHash    []byte                      `cbor:"2,keyasint"`
+		AllowList         []ctap2CredentialDescriptor `cbor:"3,keyasint,omitempty"`
+		Extensions        map[string]cbor.RawMessage  `cbor:"4,keyasint,omitempty"`
+		Options           *ctap2Options               `cbor:"5,keyasint,omitempty"`
+		PinUvAuthParam    []byte                      `cbor:"6,keyasint,omitempty"`
+		PinUvAuthProtocol uint                        `cbor:"7,keyasint,omitempty"`
+	}
+	ctap2GetAssertionResponse struct {
+		Credential          *ctap2CredentialDescriptor `cbor:"1,keyasint,omitempty"`
+		AuthData            []byte                     `cbor:"2,keyasint"`
+		Signature           []byte                     `cbor:"3,keyasint"`
+		User                *ctap2UserEntity           `cbor:"4,keyasint,omitempty"`
+		NumberOfCredentials uint                       `cbor:"5,keyasint,omitempty"`
+		UserSelected        bool                       `cbor:"6,keyasint,omitempty"`
+		LargeBlobKey        []byte                     `cbor:"7,keyasint,omitempty"`
+	}
+	ctap2ClientPINRequest struct {
+		PinUvAuthProtocol uint     `cbor:"1,keyasint,omitempty"`
+		SubCommand        uint     `cbor:"2,keyasint"`
+		KeyAgreement      *coseKey `cbor:"3,keyasint,omitempty"`
+		PinUvAuthParam    []byte   `cbor:"4,keyasint,omitempty"`
+		NewPinEnc         []byte   `cbor:"5,keyasint,omitempty"`
+		PinHashEnc        []byte   `cbor:"6,keyasint,omitempty"`
+		Permissions       uint     `cbor:"9,keyasint,omitempty"`
+		RPID              string   `cbor:"10,keyasint,omitempty"`
+	}
+	ctap2ClientPINResponse struct {
+		KeyAgreement    *coseKey `cbor:"1,keyasint,omitempty"`
+		PinUvAuthToken  []byte   `cbor:"2,keyasint,omitempty"`
+		PinRetries      uint     `cbor:"3,keyasint,omitempty"`
+		PowerCycleState bool     `cbor:"4,keyasint,omitempty"`
+		UVRetries       uint     `cbor:"5,keyasint,omitempty"`
+	}
+)
+
+// ctap2Ctors are constructors of CTAP2 messages decoded by fuzzCTAP2.
+var ctap2Ctors = []func() interface{}{
+	func() interface{} { return new(ctap2MakeCredentialRequest) },
+	func() interface{} { return new(ctap2MakeCredentialResponse) },
+	func() interface{} { return new(ctap2GetAssertionRequest) },
+	func() interface{} { return new(ctap2GetAssertionResponse) },
+	func() interface{} { return new(ctap2ClientPINRequest) },
+	func() interface{} { return new(ctap2ClientPINResponse) },
+}
+
+// dmCTAP2 decodes CTAP2 messages with the library's options that reject non-canonical
+// data (CTAP 2.1 section 8): duplicate map keys, indefinite length items, and tags.  Other
+// canonical rules are known gaps listed in ctap2KnownGaps.
+var dmCTAP2, _ = cbor.DecOptions{
+	DupMapKey:        cbor.DupMapKeyEnforcedAPF,
+	IndefLength:      cbor.IndefLengthForbidden,
+	TagsMd:           cbor.TagsForbidden,
+	MapKeyByteString: cbor.MapKeyByteStringAllowed,
+}.DecMode()
+
+// dmCTAP2Lenient is dmCTAP2 without rejecting non-canonical data.
+var dmCTAP2Lenient, _ = cbor.DecOptions{MapKeyByteString: cbor.MapKeyByteStringAllowed}.DecMode()
+
+var emCTAP2, _ = cbor.CTAP2EncOptions().EncMode()
+
+// ctap2KnownGaps are CTAP2 canonical rules that the library has no decoding options for, so
+// dmCTAP2 accepts data violating them.
+var ctap2KnownGaps = []struct {
+	name     string
+	violates func(item *dataItem, t reflect.Type) bool
+}{
+	{
+		"map keys aren't sorted in CTAP2 order",
+		func(item *dataItem, t reflect.Type) bool { return hasUnsortedMapKeys(item) },
+	},
+	{
+		"heads aren't shortest",
+		func(item *dataItem, t reflect.Type) bool { return hasNonShortestHead(item) },
+	},
+	{
+		"maps skipped for unknown struct fields or kept in cbor.RawMessage have duplicate keys",
+		hasUndecodedDuplicateMapKeys,
+	},
+}
+
+// fuzzCTAP2 decodes data to CTAP2 messages with dmCTAP2 and dmCTAP2Lenient, and checks
+// acceptance against ctap2Canonical.  Canonical data must be decoded the same as with
+// dmCTAP2Lenient.  Non-canonical data must be rejected with the first tag or indefinite
+// length item, or DupMapKeyError for maps with duplicate keys, and can be accepted only if
+// it violates a rule in ctap2KnownGaps.  Decoded messages must be encoded with CTAP2
+// encoding mode to canonical data and round trip.
+func fuzzCTAP2(data []byte, item *dataItem) {
+	if len(item.raw) != len(data) {
+		return
+	}
+	canonical := ctap2Canonical(item)
+
+	// Tags and indefinite length are rejected while checking well-formedness, before
+	// decoding to Go value.
+	var wantErr error
+	item.walk(func(it *dataItem) {
+		if wantErr != nil {
+			return
+		}
+		if it.major == majorTypeTag {
+			wantErr = &cbor.TagsMdError{}
+		} else if it.indef {
+			wantErr = &cbor.IndefiniteLengthError{}
+		}
+	})
+
+	for _, ctor := range ctap2Ctors {
+		v, lenient := ctor(), ctor()
+		t := reflect.TypeOf(v).Elem()
+		err := dmCTAP2.Unmarshal(data, v)
+		lenientErr := dmCTAP2Lenient.Unmarshal(data, lenient)
+
+		if wantErr != nil {
+			if reflect.TypeOf(err) != reflect.TypeOf(wantErr) {
+				panic(fmt.Sprintf("decoding 0x%x to %s with CTAP2 decoding mode returned %v, want %T", data, t, err, wantErr))
+			}
+			continue
+		}
+		if _, ok := err.(*cbor.DupMapKeyError); ok {
+			// Floats are canonical in any width, so keys of canonical map can be the
+			// same Go value.
+			if !hasDuplicateMapKeys(item) {
+				panic(fmt.Sprintf("decoding 0x%x without duplicate map keys to %s with CTAP2 decoding mode returned %v", data, t, err))
+			}
+			continue
+		}
+		if lenientErr == nil && item.major == majorTypeMap {
+			// Struct fields are matched before values are decoded, so duplicate keys of
+			// decoded message are detected.
+			if key, index := duplicateMapKey(item, t); index >= 0 {
+				panic(fmt.Sprintf("decoding 0x%x with duplicate key %v at index %d to %s with CTAP2 decoding mode returned %v, want DupMapKeyError", data, key, index, t, err))
+			}
+		}
+		if err != nil && lenientErr == nil {
+			panic(fmt.Sprintf("decoding 0x%x (canonical %t) to %s with CTAP2 decoding mode returned %v, want no error", data, canonical, t, err))
+		}
+		if (err == nil) != (lenientErr == nil) || (err == nil && !DeepEqual(v, lenient)) {
+			panic(fmt.Sprintf("decoding 0x%x to %s with CTAP2 decoding mode returned %+v (%v), want %+v (%v)", data, t, v, err, lenient, lenientErr))
+		}
+		if err != nil {
+			continue
+		}
+		if !canonical && !violatesCTAP2KnownGap(item, t) {
+			panic(fmt.Sprintf("decoding non-canonical 0x%x to %s with CTAP2 decoding mode returned no error", data, t))
+		}
+
+		b, err := emCTAP2.Marshal(v)
+		if err != nil {
+			panic(fmt.Sprintf("encoding %s with CTAP2 encoding mode returned %v", t, err))
+		}
+		encoded, rest, err := parseDataItem(b)
+		if err != nil || len(rest) > 0 {
+			panic(fmt.Sprintf("encoding %s with CTAP2 encoding mode produced malformed CBOR data 0x%x: %v", t, b, err))
+		}
+		// RawMessage is encoded as is, so encoded data is canonical only if RawMessages are.
+		if rawMessagesCanonical(reflect.ValueOf(v)) && !ctap2Canonical(encoded) {
+			panic(fmt.Sprintf("encoding %s with CTAP2 encoding mode produced non-canonical CBOR data 0x%x", t, b))
+		}
+		v2 := reflect.New(t)
+		if err := dmCTAP2.Unmarshal(b, v2.Interface()); err != nil {
+			panic(fmt.Sprintf("decoding 0x%x to %s with CTAP2 decoding mode returned %v", b, t, err))
+		}
+		if !DeepEqual(v, v2.Interface()) {
+			panic(fmt.Sprintf("not equal: v1 %+v, v2 %+v (%s)", v, v2.Interface(), t))
+		}
+	}
+}
+
+// violatesCTAP2KnownGap returns true if item decoded to Go type t violates any rule in
+// ctap2KnownGaps.
+func violatesCTAP2KnownGap(item *dataItem, t reflect.Type) bool {
+	for _, gap := range ctap2KnownGaps {
+		if gap.violates(item, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasUndecodedDuplicateMapKeys returns true if item decoded to Go type t has map with
+// duplicate keys that isn't decoded: value of unknown struct field, or cbor.RawMessage.
+func hasUndecodedDuplicateMapKeys(item *dataItem, t reflect.Type) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == typeRawMessage {
+		return hasDuplicateMapKeys(item)
+	}
+	switch {
+	case item.major == majorTypeMap && t.Kind() == reflect.Struct:
+		flds, _ := structFields(t)
+		for j, i := range matchStructFields(flds, item) {
+			if i < 0 && hasDuplicateMapKeys(item.value(j)) {
+				return true
+			}
+			if i >= 0 && hasUndecodedDuplicateMapKeys(item.value(j), t.Field(flds[i].idx).Type) {
+				return true
+			}
+		}
+	case item.major == majorTypeMap && t.Kind() == reflect.Map:
+		for i := 0; i < item.numPairs(); i++ {
+			if hasUndecodedDuplicateMapKeys(item.value(i), t.Elem()) {
+				return true
+			}
+		}
+	case item.major == majorTypeArray && (t.Kind() == reflect.Slice || t.Kind() == reflect.Array):
+		for _, it := range item.items {
+			if hasUndecodedDuplicateMapKeys(it, t.Elem()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasDuplicateMapKeys returns true if any map in item has duplicate keys when decoded to
+// map[interface{}]interface{} or to struct.  Struct keys are modeled the same for any struct.
+func hasDuplicateMapKeys(item *dataItem) bool {
+	found := false
+	item.walk(func(it *dataItem) {
+		if found || it.major != majorTypeMap {
+			return
+		}
+		for _, t := range []reflect.Type{reflect.TypeOf(map[interface{}]interface{}(nil)), reflect.TypeOf(ctap2Options{})} {
+			if _, index := duplicateMapKey(it, t); index >= 0 {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// rawMessagesCanonical returns true if every non-empty cbor.RawMessage in v is in CTAP2
+// canonical form.
+func rawMessagesCanonical(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil() || rawMessagesCanonical(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Type() == typeRawMessage {
+			if v.Len() == 0 {
+				return true
+			}
+			item, rest, err := parseDataItem(v.Bytes())
+			return err == nil && len(rest) == 0 && ctap2Canonical(item)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if !rawMessagesCanonical(v.Index(i)) {
+				return false
+			}
+		}
+	case reflect.Map:
+		for iter := v.MapRange(); iter.Next(); {
+			if !rawMessagesCanonical(iter.Key()) || !rawMessagesCanonical(iter.Value()) {
+				return false
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if !rawMessagesCanonical(v.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// ctap2Canonical returns true if item is in CTAP2 canonical form: heads are shortest, items
+// have definite length, map keys are unique and sorted in CTAP2 order, and there are no tags.
+// Floats are canonical in any width, because CTAP2 canonical form keeps their representation.
+func ctap2Canonical(item *dataItem) bool {
+	canonical := !hasNonShortestHead(item)
+	item.walk(func(it *dataItem) {
+		if it.major == majorTypeTag || it.indef {
+			canonical = false
+		}
+		if it.major != majorTypeMap {
+			return
+		}
+		for i := 1; i < it.numPairs(); i++ {
+			if !ctap2KeyLess(it.key(i-1).raw, it.key(i).raw) {
+				canonical = false
+			}
+		}
+	})
+	return canonical
+}
+
+// hasUnsortedMapKeys returns true if any map in item has a key sorting before the previous
+// key in CTAP2 order.  Equal keys are duplicate instead of unsorted.
+func hasUnsortedMapKeys(item *dataItem) bool {
+	found := false
+	item.walk(func(it *dataItem) {
+		if it.major != majorTypeMap {
+			return
+		}
+		for i := 1; i < it.numPairs(); i++ {
+			if ctap2KeyLess(it.key(i).raw, it.key(i-1).raw) {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// hasNonShortestHead returns true if any item other than float or simple value in item has
+// head longer than needed for its argument.
+func hasNonShortestHead(item *dataItem) bool {
+	found := false
+	item.walk(func(it *dataItem) {
+		if it.major != majorTypePrimitives && !it.indef && len(appendHead(nil, it.major, it.val)) != it.headLen {
+			found = true
+		}
+	})
+	return found
+}
+
+// ctap2KeyLess returns true if encoded map key a sorts before b in CTAP2 canonical order:
+// lower major type first, then shorter key first, then lower byte value first.
+func ctap2KeyLess(a, b []byte) bool {
+	if a[0]>>5 != b[0]>>5 {
+		return a[0]>>5 < b[0]>>5
+	}
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return bytes.Compare(a, b) < 0
+}
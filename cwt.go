@@ -0,0 +1,108 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	// cwtTagNum is CBOR tag number of CWT (RFC 8392 section 6).
+	cwtTagNum = 61
+
+	// cwtContentFormat and cwtMediaType are CoAP content format and media type of CWT,
+	// used as content type of nested CWT (RFC 8392 section 7.1).
+	cwtContentFormat = 61
+	cwtMediaType     = "application/cwt"
+
+	// maxCWTNestedLevel is the max nested level of CWTs decoded by fuzzCWT.
+	maxCWTNestedLevel = 4
+)
+
+// fuzzCWT strips CWT tag and COSE tag from item, decodes payload of COSE message to claims,
+// and checks that claims round trip.  Payload is decoded as nested CWT if content type in
+// protected header is CWT.
+func fuzzCWT(item *dataItem, level int) {
+	if level > maxCWTNestedLevel {
+		return
+	}
+	for item.major == majorTypeTag && (item.val == 55799 || item.val == cwtTagNum) {
+		item = item.tagContent()
+	}
+
+	// Type of untagged COSE message is known from context (RFC 9052 section 2).  Untagged
+	// COSE_Sign1 and COSE_Mac0 have the same structure, so payload is decoded once, and
+	// untagged COSE_Encrypt0 has no plaintext payload.
+	if item.major == majorTypeArray {
+		var m coseSign1
+		if cbor.Unmarshal(item.raw, &m) == nil {
+			fuzzCWTPayload(m.Payload, m.Protected.ContentType, level)
+		}
+		return
+	}
+	if item.major != majorTypeTag {
+		return
+	}
+
+	for _, m := range coseMessageTags {
+		if m.num != item.val {
+			continue
+		}
+		v := reflect.New(m.t)
+		if err := dmCOSE.Unmarshal(item.raw, v.Interface()); err != nil {
+			return
+		}
+		// COSE_Encrypt0 and COSE_Encrypt don't have plaintext payload.
+		payload := v.Elem().FieldByName("Payload")
+		if !payload.IsValid() {
+			return
+		}
+		protected := v.Elem().FieldByName("Protected").Interface().(coseProtectedHeader)
+		fuzzCWTPayload(payload.Bytes(), protected.ContentType, level)
+		return
+	}
+}
+
+// fuzzCWTPayload decodes CWT payload to claims, or to nested CWT if content type is CWT.
+func fuzzCWTPayload(payload []byte, contentType interface{}, level int) {
+	if isCWTContentType(contentType) {
+		nested, _, err := parseDataItem(payload)
+		if err == nil {
+			fuzzCWT(nested, level+1)
+		}
+		return
+	}
+
+	var c1 claims
+	if err := cbor.Unmarshal(payload, &c1); err != nil {
+		return
+	}
+	b, err := cbor.Marshal(c1)
+	if err != nil {
+		panic(err)
+	}
+	var c2 claims
+	if err := cbor.Unmarshal(b, &c2); err != nil {
+		panic(fmt.Sprintf("decoding claims 0x%x returned %v", b, err))
+	}
+	if !DeepEqual(c1, c2) {
+		panic(fmt.Sprintf("not equal: v1 %+v, v2 %+v (claims)", c1, c2))
+	}
+}
+
+// isCWTContentType returns true if COSE content type ct is CWT content format or media type.
+// Media types are case-insensitive.
+func isCWTContentType(ct interface{}) bool {
+	switch ct := ct.(type) {
+	case uint64:
+		return ct == cwtContentFormat
+	case string:
+		return strings.EqualFold(ct, cwtMediaType)
+	}
+	return false
+}
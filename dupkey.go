@@ -0,0 +1,200 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxFuzzMaps is the max number of CBOR maps in data checked by fuzzDuplicateMapKey.
+const maxFuzzMaps = 8
+
+// dupMapKeyTargets are Go types that CBOR maps are decoded to by fuzzDuplicateMapKey.
+var dupMapKeyTargets = []reflect.Type{
+	reflect.TypeOf(map[interface{}]interface{}(nil)),
+	reflect.TypeOf(map[string]interface{}(nil)),
+	reflect.TypeOf(map[int]interface{}(nil)),
+	reflect.TypeOf(map[cbor.SimpleValue]interface{}(nil)),
+	reflect.TypeOf(t1{}),
+	reflect.TypeOf(t2{}),
+	reflect.TypeOf(claims{}),
+	reflect.TypeOf(coseKey{}),
+	reflect.TypeOf(nestedStruct{}),
+}
+
+// fuzzDuplicateMapKey finds duplicate keys in CBOR maps in item independently for each
+// target Go type, and checks that decoding with DupMapKeyEnforcedAPF returns DupMapKeyError
+// with the same key and index exactly when map has duplicate keys.
+func fuzzDuplicateMapKey(item *dataItem) {
+	n := 0
+	item.walk(func(it *dataItem) {
+		if n >= maxFuzzMaps || it.major != majorTypeMap {
+			return
+		}
+		n++
+
+		for _, t := range dupMapKeyTargets {
+			v := reflect.New(t)
+			err := dmDupMapKeyEnforcedAPF.NewDecoder(bytes.NewReader(it.raw)).Decode(v.Interface())
+
+			key, index := duplicateMapKey(it, t)
+			if index >= 0 {
+				e, ok := err.(*cbor.DupMapKeyError)
+				if !ok {
+					panic(fmt.Sprintf("decoding CBOR map 0x%x with duplicate key %v at index %d to %s returned %v, want DupMapKeyError", it.raw, key, index, t, err))
+				}
+				if e.Index != index || !DeepEqual(e.Key, key) {
+					panic(fmt.Sprintf("decoding CBOR map 0x%x to %s returned DupMapKeyError with key %v (%T) at index %d, want key %v (%T) at index %d", it.raw, t, e.Key, e.Key, e.Index, key, key, index))
+				}
+				continue
+			}
+
+			// DupMapKeyError without duplicate keys in this map must be from nested map.
+			if e, ok := err.(*cbor.DupMapKeyError); ok && !hasNestedDupMapKeyError(it, e) {
+				panic(fmt.Sprintf("decoding CBOR map 0x%x without duplicate keys to %s returned %v", it.raw, t, err))
+			}
+		}
+	})
+}
+
+// duplicateMapKey returns the first duplicate key in map item and its pair index, when
+// map item is decoded to Go type t.  Index is -1 if map item doesn't have duplicate keys.
+func duplicateMapKey(item *dataItem, t reflect.Type) (interface{}, int) {
+	keys := make(map[interface{}]struct{})
+	var prev interface{}
+	if t.Kind() == reflect.Map {
+		prev = reflect.Zero(t.Key()).Interface()
+	}
+	for i := 0; i < item.numPairs(); i++ {
+		k, ok := mapKeyModel(item.key(i), item.value(i), t, &prev)
+		if !ok {
+			continue
+		}
+		if _, dup := keys[k]; dup {
+			return k, i
+		}
+		keys[k] = struct{}{}
+	}
+	return nil, -1
+}
+
+// mapKeyModel returns Go value used to detect duplicate key k when map item is decoded to
+// Go type t, and false if key-value pair is skipped.  prev is the last key decoded to Go map
+// key type, and is updated with k.
+func mapKeyModel(k, v *dataItem, t reflect.Type, prev *interface{}) (interface{}, bool) {
+	if t.Kind() == reflect.Struct {
+		// Struct field names are matched before field value is decoded, so duplicate
+		// keys are detected regardless of value.
+		switch k.major {
+		case majorTypeTextString:
+			if !validTextString(k) {
+				return nil, false
+			}
+			return string(k.content), true
+		case majorTypePositiveInt:
+			return int64(k.val), true
+		case majorTypeNegativeInt:
+			if k.val > math.MaxInt64 {
+				return nil, false
+			}
+			return -1 ^ int64(k.val), true
+		}
+		var gk interface{}
+		if dmDupMapKeyEnforcedAPF.Unmarshal(k.raw, &gk) != nil || !isHashable(gk) {
+			return nil, false
+		}
+		return gk, true
+	}
+
+	// Key-value pair is added to Go map before duplicate keys are detected, so pair is
+	// skipped if key or value can't be decoded.
+	gk := reflect.New(t.Key())
+	if dmDupMapKeyEnforcedAPF.Unmarshal(k.raw, gk.Interface()) != nil {
+		return nil, false
+	}
+	key := gk.Elem().Interface()
+	if isImmutableKind(t.Key().Kind()) && t.Key() != typeSimpleValue && isNull(skipTags(k)) {
+		// Key of immutable kind is decoded to the same Go value for each pair, and decoding
+		// null and undefined is no-op except to cbor.SimpleValue, so key is the previous key.
+		key = *prev
+	}
+	*prev = key
+	if t.Key().Kind() == reflect.Interface {
+		key = byteStringMapKey(key)
+		if !isHashable(key) {
+			return nil, false
+		}
+	}
+	gv := reflect.New(t.Elem())
+	if dmDupMapKeyEnforcedAPF.Unmarshal(v.raw, gv.Interface()) != nil {
+		return nil, false
+	}
+	return key, true
+}
+
+// hasNestedDupMapKeyError returns true if any key or value in map item returns
+// DupMapKeyError e when decoded to interface{}.  Integer keys of nested map decoded to struct
+// are int64 instead of uint64, so keys are compared by printed value.
+func hasNestedDupMapKeyError(item *dataItem, e *cbor.DupMapKeyError) bool {
+	for _, it := range item.items {
+		var v interface{}
+		err := dmDupMapKeyEnforcedAPF.Unmarshal(it.raw, &v)
+		if ne, ok := err.(*cbor.DupMapKeyError); ok && ne.Index == e.Index && fmt.Sprint(ne.Key) == fmt.Sprint(e.Key) {
+			return true
+		}
+	}
+	return false
+}
+
+// isImmutableKind returns true if Go value of kind k is reused for each decoded map key.
+func isImmutableKind(k reflect.Kind) bool {
+	return k == reflect.Bool || k == reflect.String || (k != reflect.Uintptr && isNumberKind(k))
+}
+
+// skipTags returns item without leading tag numbers.
+func skipTags(item *dataItem) *dataItem {
+	for item.major == majorTypeTag {
+		item = item.tagContent()
+	}
+	return item
+}
+
+// isHashable returns true if v can be Go map key.
+func isHashable(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	return isHashableType(reflect.TypeOf(v), reflect.ValueOf(v))
+}
+
+func isHashableType(t reflect.Type, v reflect.Value) bool {
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map, reflect.Func:
+		return false
+	case reflect.Interface:
+		if v.IsNil() {
+			return true
+		}
+		return isHashableType(v.Elem().Type(), v.Elem())
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if !isHashableType(t.Elem(), v.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if !isHashableType(t.Field(i).Type, v.Field(i)) {
+				return false
+			}
+		}
+	}
+	return true
+}
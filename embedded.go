@@ -0,0 +1,122 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+const (
+	// embeddedCBORTagNum is tag number of encoded CBOR data item (RFC 8949 section 3.4.5.1).
+	embeddedCBORTagNum = 24
+
+	// maxEmbeddedLevel is the max nested level of embedded CBOR data fuzzed recursively.
+	maxEmbeddedLevel = 1
+
+	// maxEmbeddedBytes and maxEmbeddedItems are the max total size and number of embedded
+	// CBOR data items fuzzed recursively for data passed to Fuzz.
+	maxEmbeddedBytes = 1024
+	maxEmbeddedItems = 1
+)
+
+// embeddedBudget is what's left for fuzzing embedded CBOR data.  It's shared by all nested
+// levels.
+type embeddedBudget struct {
+	level int
+	bytes int
+	items int
+}
+
+func newEmbeddedBudget() *embeddedBudget {
+	return &embeddedBudget{bytes: maxEmbeddedBytes, items: maxEmbeddedItems}
+}
+
+// embeddedCBOR is encoded CBOR data item registered with tag 24.
+type embeddedCBOR []byte
+
+var embeddedCBORTags = func() cbor.TagSet {
+	tags := cbor.NewTagSet()
+	if err := tags.Add(cbor.TagOptions{EncTag: cbor.EncTagRequired, DecTag: cbor.DecTagRequired}, reflect.TypeOf(embeddedCBOR(nil)), embeddedCBORTagNum); err != nil {
+		panic(err)
+	}
+	return tags
+}()
+
+var (
+	dmEmbeddedCBOR, _ = cbor.DecOptions{}.DecModeWithTags(embeddedCBORTags)
+	emEmbeddedCBOR, _ = cbor.EncOptions{}.EncModeWithTags(embeddedCBORTags)
+)
+
+// fuzzEmbedded finds byte strings in item that are well-formed CBOR arrays, maps, or tags,
+// and runs Fuzz on them recursively within budget.  Other byte strings, such as short ones
+// that happen to be integers, are skipped.  Tag 24 items are round tripped with registered
+// type embeddedCBOR.
+func fuzzEmbedded(item *dataItem, budget *embeddedBudget) {
+	var embedded [][]byte
+	item.walk(func(it *dataItem) {
+		switch {
+		case it.major == majorTypeTag && it.val == embeddedCBORTagNum && it.tagContent().major == majorTypeByteString:
+			fuzzEmbeddedCBORTag(it)
+		case it.major == majorTypeByteString && len(it.content) > 0:
+			// Chunks of indefinite length byte string are concatenated.
+			e, rest, err := parseDataItem(it.content)
+			if err == nil && len(rest) == 0 && (e.major == majorTypeArray || e.major == majorTypeMap || e.major == majorTypeTag) {
+				embedded = append(embedded, it.content)
+			}
+		}
+	})
+
+	if budget.level >= maxEmbeddedLevel {
+		return
+	}
+	for _, b := range embedded {
+		if budget.items <= 0 || budget.bytes < len(b) {
+			return
+		}
+		budget.items--
+		budget.bytes -= len(b)
+		budget.level++
+		fuzz(b, budget)
+		budget.level--
+	}
+}
+
+// fuzzEmbeddedCBORTag decodes tag 24 item to embeddedCBOR and interface{} with tag 24
+// registered, and checks that both are encoded to tag 24 with the same content in shortest
+// form.
+func fuzzEmbeddedCBORTag(item *dataItem) {
+	content := item.tagContent().content
+	want := appendHead(appendHead(nil, majorTypeTag, embeddedCBORTagNum), majorTypeByteString, uint64(len(content)))
+	want = append(want, content...)
+
+	var e embeddedCBOR
+	if err := dmEmbeddedCBOR.Unmarshal(item.raw, &e); err != nil {
+		panic(fmt.Sprintf("decoding tag 24 0x%x to embeddedCBOR returned %v", item.raw, err))
+	}
+	if !bytes.Equal(e, content) {
+		panic(fmt.Sprintf("decoding tag 24 0x%x to embeddedCBOR returned 0x%x, want 0x%x", item.raw, []byte(e), content))
+	}
+
+	var v interface{}
+	if err := dmEmbeddedCBOR.Unmarshal(item.raw, &v); err != nil {
+		panic(fmt.Sprintf("decoding tag 24 0x%x to interface{} returned %v", item.raw, err))
+	}
+	if ve, ok := v.(embeddedCBOR); !ok || !bytes.Equal(ve, content) {
+		panic(fmt.Sprintf("decoding tag 24 0x%x to interface{} returned %v (%T), want embeddedCBOR 0x%x", item.raw, v, v, content))
+	}
+
+	for _, x := range []interface{}{e, v} {
+		b, err := emEmbeddedCBOR.Marshal(x)
+		if err != nil {
+			panic(fmt.Sprintf("encoding embeddedCBOR returned %v", err))
+		}
+		if !bytes.Equal(b, want) {
+			panic(fmt.Sprintf("encoding embeddedCBOR decoded from tag 24 0x%x produced 0x%x, want 0x%x", item.raw, b, want))
+		}
+	}
+}
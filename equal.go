@@ -0,0 +1,203 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"math/big"
+	"reflect"
+	"time"
+)
+
+// roundTripEqual is DeepEqual for value v1 and value v2 round tripped with emCoreDeterministic,
+// except that:
+//  1. time.Time values are compared with Equal at TimeUnix precision.
+//  2. big.Int values are compared with Cmp.
+//  3. time.Time in interface{} is encoded as untagged epoch time, so it equals decoded integer,
+//     or nil if it's zero time.
+//  4. big.Int in interface{} is encoded as CBOR integer if it fits, so it equals decoded
+//     int64 or uint64.
+//  5. Map keys changed by round trip are matched by roundTripEqual.
+func roundTripEqual(v1, v2 interface{}) bool {
+	return roundTripValueEqual(reflect.ValueOf(v1), reflect.ValueOf(v2))
+}
+
+func roundTripValueEqual(v1, v2 reflect.Value) bool {
+	if !v1.IsValid() || !v2.IsValid() {
+		return v1.IsValid() == v2.IsValid()
+	}
+
+	if v1.Kind() == reflect.Interface && v2.Kind() == reflect.Interface && !v1.IsNil() {
+		e1, e2 := v1.Elem(), v2.Elem()
+		if !e2.IsValid() || e1.Type() != e2.Type() {
+			switch e1.Type() {
+			case typeTime:
+				return untaggedTimeEqual(e1.Interface().(time.Time), e2)
+			case typeBigInt:
+				bi := e1.Interface().(big.Int)
+				return integerEqual(&bi, e2)
+			}
+		}
+	}
+
+	if v1.Type() != v2.Type() {
+		return false
+	}
+
+	switch v1.Type() {
+	case typeTime:
+		t1, t2 := v1.Interface().(time.Time), v2.Interface().(time.Time)
+		if t1.IsZero() || t2.IsZero() {
+			return t1.IsZero() == t2.IsZero()
+		}
+		return time.Unix(t1.Unix(), 0).Equal(time.Unix(t2.Unix(), 0))
+	case typeBigInt:
+		bi1, bi2 := v1.Interface().(big.Int), v2.Interface().(big.Int)
+		return bi1.Cmp(&bi2) == 0
+	}
+
+	switch v1.Kind() {
+	case reflect.Array, reflect.Slice:
+		if v1.Len() != v2.Len() {
+			return false
+		}
+		for i := 0; i < v1.Len(); i++ {
+			if !roundTripValueEqual(v1.Index(i), v2.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Interface:
+		if v1.IsNil() || v2.IsNil() {
+			return v1.IsNil() == v2.IsNil()
+		}
+		return roundTripValueEqual(v1.Elem(), v2.Elem())
+	case reflect.Ptr:
+		return roundTripValueEqual(v1.Elem(), v2.Elem())
+	case reflect.Struct:
+		for i, n := 0, v1.NumField(); i < n; i++ {
+			if !roundTripValueEqual(v1.Field(i), v2.Field(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Map:
+		if v1.Len() != v2.Len() {
+			// Distinct keys, such as time.Time with different locations, can be
+			// encoded to the same CBOR data, and only one of them is decoded.
+			return hasRoundTripEqualKeys(v1)
+		}
+		for _, k1 := range v1.MapKeys() {
+			if e2 := v2.MapIndex(k1); e2.IsValid() {
+				if !roundTripValueEqual(v1.MapIndex(k1), e2) {
+					return false
+				}
+				continue
+			}
+			found := false
+			for _, k2 := range v2.MapKeys() {
+				if roundTripValueEqual(k1, k2) && roundTripValueEqual(v1.MapIndex(k1), v2.MapIndex(k2)) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	}
+	return deepValueEqual(v1, v2, make(map[visit]bool))
+}
+
+// untaggedTimeEqual returns true if t encoded as untagged epoch time is decoded to v in interface{}.
+func untaggedTimeEqual(t time.Time, v reflect.Value) bool {
+	if t.IsZero() {
+		return !v.IsValid()
+	}
+	if !v.IsValid() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Int64:
+		return t.Unix() == v.Int()
+	case reflect.Uint64:
+		return t.Unix() >= 0 && uint64(t.Unix()) == v.Uint()
+	}
+	return false
+}
+
+// integerEqual returns true if bi encoded as CBOR integer is decoded to v in interface{}.
+func integerEqual(bi *big.Int, v reflect.Value) bool {
+	if !v.IsValid() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Int64:
+		return bi.IsInt64() && bi.Int64() == v.Int()
+	case reflect.Uint64:
+		return bi.IsUint64() && bi.Uint64() == v.Uint()
+	}
+	return false
+}
+
+// hasRoundTripEqualKeys returns true if map v has distinct keys that are equal after round trip.
+func hasRoundTripEqualKeys(v reflect.Value) bool {
+	keys := v.MapKeys()
+	hasTime := false
+	for _, k := range keys {
+		if hasType(k, typeTime) {
+			hasTime = true
+			break
+		}
+	}
+	if !hasTime {
+		// Only time.Time keys lose precision in round trip.
+		return false
+	}
+	for i := range keys {
+		for j := i + 1; j < len(keys); j++ {
+			if roundTripValueEqual(keys[i], keys[j]) || roundTripValueEqual(keys[j], keys[i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// hasRoundTripEqualMapKeys returns true if any map in v has distinct keys that are encoded
+// to the same CBOR data.
+func hasRoundTripEqualMapKeys(v reflect.Value) bool {
+	if !v.IsValid() {
+		return false
+	}
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return !v.IsNil() && hasRoundTripEqualMapKeys(v.Elem())
+	case reflect.Struct:
+		if v.Type() == typeTime || v.Type() == typeBigInt {
+			return false
+		}
+		for i := 0; i < v.NumField(); i++ {
+			if hasRoundTripEqualMapKeys(v.Field(i)) {
+				return true
+			}
+		}
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			if hasRoundTripEqualMapKeys(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Map:
+		if hasRoundTripEqualKeys(v) {
+			return true
+		}
+		for _, k := range v.MapKeys() {
+			if hasRoundTripEqualMapKeys(k) || hasRoundTripEqualMapKeys(v.MapIndex(k)) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,231 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxFuzzFloats is the max number of floats in a decoded value checked by fuzzFloat.
+const maxFuzzFloats = 64
+
+// floatEncOptions are combinations of ShortestFloat, NaNConvert, and InfConvert options.
+var floatEncOptions = func() []cbor.EncOptions {
+	var opts []cbor.EncOptions
+	for _, sf := range []cbor.ShortestFloatMode{cbor.ShortestFloatNone, cbor.ShortestFloat16} {
+		for _, nc := range []cbor.NaNConvertMode{cbor.NaNConvert7e00, cbor.NaNConvertNone, cbor.NaNConvertPreserveSignal, cbor.NaNConvertQuiet} {
+			for _, ic := range []cbor.InfConvertMode{cbor.InfConvertFloat16, cbor.InfConvertNone} {
+				opts = append(opts, cbor.EncOptions{ShortestFloat: sf, NaNConvert: nc, InfConvert: ic})
+			}
+		}
+	}
+	return opts
+}()
+
+// floatEncModes are preset encoding modes and encoding modes created with floatEncOptions.
+var floatEncModes = func() []cbor.EncMode {
+	ems := []cbor.EncMode{emPreferred, emCanonical, emCTAP2TagsAllowed, emCoreDeterministic}
+	for _, opts := range floatEncOptions {
+		em, err := opts.EncMode()
+		if err != nil {
+			panic(err)
+		}
+		ems = append(ems, em)
+	}
+	return ems
+}()
+
+// float16Values maps float32 bits of every non-NaN float16 value to float16 bits.
+// A finite float32 can be encoded as float16 without losing precision iff it's in this map.
+var float16Values = func() map[uint32]uint16 {
+	m := make(map[uint32]uint16)
+	for h := 0; h <= 0xffff; h++ {
+		if h&0x7c00 == 0x7c00 && h&0x3ff != 0 {
+			continue
+		}
+		m[math.Float32bits(float16ToFloat32(uint16(h)))] = uint16(h)
+	}
+	return m
+}()
+
+// fuzzFloat encodes floats in decoded value v with each encoding mode in floatEncModes,
+// and checks encoded data and round tripped values against floatEncodingModel with the
+// mode's float options.
+func fuzzFloat(v interface{}) {
+	var floats []reflect.Value
+	collectFloats(reflect.ValueOf(v), &floats)
+
+	for _, fv := range floats {
+		for _, em := range floatEncModes {
+			opts := em.EncOptions()
+
+			b, err := em.Marshal(fv.Interface())
+			if err != nil {
+				panic(err)
+			}
+			want := floatEncodingModel(fv, opts)
+			if !bytes.Equal(b, want) {
+				panic(fmt.Sprintf("encoding %s %v with %+v returned 0x%x, want 0x%x", fv.Type(), fv, opts, b, want))
+			}
+
+			// Decode to the same type and compare bits.
+			rv := reflect.New(fv.Type())
+			if err := cbor.Unmarshal(b, rv.Interface()); err != nil {
+				panic(err)
+			}
+			got, orig := floatBits(rv.Elem()), floatBits(fv)
+			if !math.IsNaN(fv.Float()) {
+				if got != orig {
+					panic(fmt.Sprintf("%s %v (0x%x) round tripped with %+v to 0x%x", fv.Type(), fv, orig, opts, got))
+				}
+				continue
+			}
+			if !math.IsNaN(rv.Elem().Float()) {
+				panic(fmt.Sprintf("%s NaN (0x%x) round tripped with %+v to %v", fv.Type(), orig, opts, rv.Elem()))
+			}
+			if opts.NaNConvert == cbor.NaNConvert7e00 {
+				continue
+			}
+			// Sign and payload are preserved.  Quiet bit may be set by Go float conversion
+			// when decoding, and it is set by NaNConvertQuiet when encoding.
+			quiet := uint64(1) << 51
+			if fv.Kind() == reflect.Float32 {
+				quiet = 1 << 22
+			}
+			if got|quiet != orig|quiet {
+				panic(fmt.Sprintf("%s NaN (0x%x) round tripped with %+v to 0x%x", fv.Type(), orig, opts, got))
+			}
+		}
+	}
+}
+
+// hasFloatSource returns true if item or any item nested inside it can be decoded to Go
+// float: integer, float, simple value other than false, true, null, and undefined, or bignum.
+func hasFloatSource(item *dataItem) bool {
+	found := false
+	item.walk(func(it *dataItem) {
+		switch it.major {
+		case majorTypePositiveInt, majorTypeNegativeInt:
+			found = true
+		case majorTypePrimitives:
+			if it.ai < 20 || it.ai > 23 {
+				found = true
+			}
+		case majorTypeTag:
+			if it.val == 2 || it.val == 3 {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// collectFloats appends float32 and float64 values in v to floats, up to maxFuzzFloats.
+func collectFloats(v reflect.Value, floats *[]reflect.Value) {
+	if !v.IsValid() || len(*floats) >= maxFuzzFloats {
+		return
+	}
+	switch v.Kind() {
+	case reflect.Float32, reflect.Float64:
+		*floats = append(*floats, v)
+	case reflect.Interface, reflect.Ptr:
+		if !v.IsNil() {
+			collectFloats(v.Elem(), floats)
+		}
+	case reflect.Array, reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			collectFloats(v.Index(i), floats)
+		}
+	case reflect.Map:
+		for iter := v.MapRange(); iter.Next(); {
+			collectFloats(iter.Key(), floats)
+			collectFloats(iter.Value(), floats)
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).PkgPath == "" {
+				collectFloats(v.Field(i), floats)
+			}
+		}
+	}
+}
+
+// floatBits returns IEEE 754 bits of float32 or float64 value v, without float conversion.
+func floatBits(v reflect.Value) uint64 {
+	if v.Kind() == reflect.Float32 {
+		return uint64(math.Float32bits(v.Interface().(float32)))
+	}
+	return math.Float64bits(v.Float())
+}
+
+// floatEncodingModel returns CBOR encoding of float value v with float options in opts.
+func floatEncodingModel(v reflect.Value, opts cbor.EncOptions) []byte {
+	bits := floatBits(v)
+	f := v.Float()
+
+	switch {
+	case math.IsNaN(f):
+		switch opts.NaNConvert {
+		case cbor.NaNConvert7e00:
+			return []byte{0xf9, 0x7e, 0x00}
+		case cbor.NaNConvertNone:
+			return appendFloat(nil, v.Kind(), bits)
+		}
+
+		// Normalize sign and payload to float64 layout.
+		sign, payload := bits>>63, bits&(1<<52-1)
+		if v.Kind() == reflect.Float32 {
+			sign, payload = bits>>31, (bits&(1<<23-1))<<29
+		}
+		if opts.NaNConvert == cbor.NaNConvertQuiet {
+			payload |= 1 << 51
+		}
+		// NaN is encoded in the shortest float whose payload bits can hold the payload.
+		switch {
+		case payload&(1<<29-1) != 0:
+			return appendFloat(nil, reflect.Float64, sign<<63|0x7ff<<52|payload)
+		case payload&(1<<42-1) != 0:
+			return appendFloat(nil, reflect.Float32, sign<<31|0xff<<23|payload>>29)
+		}
+		return appendFloat16(nil, uint16(sign<<15|0x1f<<10|payload>>42))
+
+	case math.IsInf(f, 0):
+		if opts.InfConvert == cbor.InfConvertFloat16 {
+			if f > 0 {
+				return []byte{0xf9, 0x7c, 0x00}
+			}
+			return []byte{0xf9, 0xfc, 0x00}
+		}
+		return appendFloat(nil, v.Kind(), bits)
+	}
+
+	if opts.ShortestFloat == cbor.ShortestFloatNone {
+		return appendFloat(nil, v.Kind(), bits)
+	}
+	if float64(float32(f)) != f {
+		return appendFloat(nil, reflect.Float64, bits)
+	}
+	f32bits := math.Float32bits(float32(f))
+	if h, ok := float16Values[f32bits]; ok {
+		return appendFloat16(nil, h)
+	}
+	return appendFloat(nil, reflect.Float32, uint64(f32bits))
+}
+
+// appendFloat appends CBOR float32 or float64 with bits to b.
+func appendFloat(b []byte, k reflect.Kind, bits uint64) []byte {
+	if k == reflect.Float32 {
+		return append(b, 0xfa, byte(bits>>24), byte(bits>>16), byte(bits>>8), byte(bits))
+	}
+	return append(b, 0xfb, byte(bits>>56), byte(bits>>48), byte(bits>>40), byte(bits>>32), byte(bits>>24), byte(bits>>16), byte(bits>>8), byte(bits))
+}
+
+func appendFloat16(b []byte, h uint16) []byte {
+	return append(b, 0xf9, byte(h>>8), byte(h))
+}
@@ -6,12 +6,12 @@ package cbor
 import (
 	"bytes"
 	"fmt"
-	"io/ioutil"
+	"math"
 	"math/big"
 	"reflect"
 	"time"
 
-	"github.com/fxamacker/cbor"
+	"github.com/fxamacker/cbor/v2"
 )
 
 type (
@@ -25,9 +25,10 @@ type (
 		Cti []byte  `cbor:"7,keyasint"`
 	}
 	coseHeader struct {
-		Alg int    `cbor:"1,keyasint,omitempty"`
-		Kid []byte `cbor:"4,keyasint,omitempty"`
-		IV  []byte `cbor:"5,keyasint,omitempty"`
+		Alg         int         `cbor:"1,keyasint,omitempty"`
+		ContentType interface{} `cbor:"3,keyasint,omitempty"` // uint or text string
+		Kid         []byte      `cbor:"4,keyasint,omitempty"`
+		IV          []byte      `cbor:"5,keyasint,omitempty"`
 	}
 	signedCWT struct {
 		_           struct{} `cbor:",toarray"`
@@ -92,6 +93,26 @@ type (
 	marshaller struct {
 		v string
 	}
+	treeNode struct {
+		Value    int
+		Children []*treeNode
+	}
+	treeArrayNode struct {
+		_        struct{} `cbor:",toarray"`
+		Value    int
+		Children []*treeArrayNode
+	}
+	listNode struct {
+		Value interface{}
+		Next  *listNode
+	}
+	nestedStruct struct {
+		Tree   *treeNode              `cbor:"1,keyasint,omitempty"`
+		List   *listNode              `cbor:"2,keyasint,omitempty"`
+		Nodes  map[string]*treeNode   `cbor:"3,keyasint,omitempty"`
+		Values map[string]interface{} `cbor:"4,keyasint,omitempty"`
+		Next   *nestedStruct          `cbor:"5,keyasint,omitempty"`
+	}
 )
 
 func (m *marshaller) MarshalCBOR() ([]byte, error) {
@@ -103,19 +124,26 @@ func (m *marshaller) UnmarshalCBOR(data []byte) error {
 }
 
 var (
-	typeTime   = reflect.TypeOf(time.Time{})
-	typeBigInt = reflect.TypeOf(big.Int{})
-	typeTag    = reflect.TypeOf(cbor.Tag{})
-	typeRawTag = reflect.TypeOf(cbor.RawTag{})
+	typeTime       = reflect.TypeOf(time.Time{})
+	typeBigInt     = reflect.TypeOf(big.Int{})
+	typeTag        = reflect.TypeOf(cbor.Tag{})
+	typeRawTag     = reflect.TypeOf(cbor.RawTag{})
+	typeRawMessage = reflect.TypeOf(cbor.RawMessage(nil))
+
+	typeSimpleValue = reflect.TypeOf(cbor.SimpleValue(0))
+	typeUnmarshaler = reflect.TypeOf((*cbor.Unmarshaler)(nil)).Elem()
 )
 
 var (
+	dmDefault, _                = cbor.DecOptions{}.DecMode()
 	dmDupMapKeyEnforcedAPF, _   = cbor.DecOptions{DupMapKey: cbor.DupMapKeyEnforcedAPF}.DecMode()
 	dmIntDecConvertSigned, _    = cbor.DecOptions{IntDec: cbor.IntDecConvertSigned}.DecMode()
 	dmExtraErrorUnknownField, _ = cbor.DecOptions{ExtraReturnErrors: cbor.ExtraDecErrorUnknownField}.DecMode()
+	dmMaxNestedLevels4, _       = cbor.DecOptions{MaxNestedLevels: 4}.DecMode()
 )
 
 var (
+	emDefault, _               = cbor.EncOptions{}.EncMode()
 	emPreferred, _             = cbor.PreferredUnsortedEncOptions().EncMode()
 	emCanonical, _             = cbor.CanonicalEncOptions().EncMode()
 	emCoreDeterministic, _     = cbor.CoreDetEncOptions().EncMode()
@@ -124,143 +152,242 @@ var (
 	emTimeUnixDynamic, _       = cbor.EncOptions{Time: cbor.TimeUnixDynamic}.EncMode()
 	emTimeRFC3339, _           = cbor.EncOptions{Time: cbor.TimeRFC3339}.EncMode()
 	emTimeRFC3339Nano, _       = cbor.EncOptions{Time: cbor.TimeRFC3339Nano}.EncMode()
+	emTimeUnixTag, _           = cbor.EncOptions{Time: cbor.TimeUnix, TimeTag: cbor.EncTagRequired}.EncMode()
+	emTimeUnixMicroTag, _      = cbor.EncOptions{Time: cbor.TimeUnixMicro, TimeTag: cbor.EncTagRequired}.EncMode()
+	emTimeUnixDynamicTag, _    = cbor.EncOptions{Time: cbor.TimeUnixDynamic, TimeTag: cbor.EncTagRequired}.EncMode()
+	emTimeRFC3339Tag, _        = cbor.EncOptions{Time: cbor.TimeRFC3339, TimeTag: cbor.EncTagRequired}.EncMode()
+	emTimeRFC3339NanoTag, _    = cbor.EncOptions{Time: cbor.TimeRFC3339Nano, TimeTag: cbor.EncTagRequired}.EncMode()
 	emBigIntConvertShortest, _ = cbor.EncOptions{BigIntConvert: cbor.BigIntConvertShortest}.EncMode()
 	emBigIntConvertNone, _     = cbor.EncOptions{BigIntConvert: cbor.BigIntConvertNone}.EncMode()
 )
 
+// emCTAP2TagsAllowed is "CTAP2 Canonical" encoding mode with TagsAllowed, which is needed
+// to avoid error when encoding CBOR tags.
+var emCTAP2TagsAllowed = func() cbor.EncMode {
+	opts := cbor.CTAP2EncOptions()
+	opts.TagsMd = cbor.TagsAllowed
+	em, err := opts.EncMode()
+	if err != nil {
+		panic(err)
+	}
+	return em
+}()
+
+// ctors create Go values that CBOR data is decoded to.
+var ctors = []func() interface{}{
+	func() interface{} { return nil },
+	func() interface{} { return new(interface{}) },
+	func() interface{} { return new(bool) },
+	func() interface{} { return new(uint) },
+	func() interface{} { return new(uint8) },
+	func() interface{} { return new(uint16) },
+	func() interface{} { return new(uint32) },
+	func() interface{} { return new(uint64) },
+	func() interface{} { return new(int) },
+	func() interface{} { return new(int8) },
+	func() interface{} { return new(int16) },
+	func() interface{} { return new(int32) },
+	func() interface{} { return new(int64) },
+	func() interface{} { return new(float32) },
+	func() interface{} { return new(float64) },
+	func() interface{} { return new(string) },
+	func() interface{} { return new([]byte) },
+	func() interface{} { return new([1]byte) },
+	func() interface{} { return new([10]byte) },
+	func() interface{} { return new([]interface{}) },
+	func() interface{} { return new([1]interface{}) },
+	func() interface{} { return new([10]interface{}) },
+	func() interface{} { return new([]bool) },
+	func() interface{} { return new([1]bool) },
+	func() interface{} { return new([10]bool) },
+	func() interface{} { return new([]*bool) },
+	func() interface{} { return new([1]*bool) },
+	func() interface{} { return new([10]*bool) },
+	func() interface{} { return new([]uint) },
+	func() interface{} { return new([1]uint) },
+	func() interface{} { return new([10]uint) },
+	func() interface{} { return new([]*uint) },
+	func() interface{} { return new([1]*uint) },
+	func() interface{} { return new([10]*uint) },
+	func() interface{} { return new([]uint8) },
+	func() interface{} { return new([1]uint8) },
+	func() interface{} { return new([10]uint8) },
+	func() interface{} { return new([]*uint8) },
+	func() interface{} { return new([1]*uint8) },
+	func() interface{} { return new([10]*uint8) },
+	func() interface{} { return new([]uint16) },
+	func() interface{} { return new([1]uint16) },
+	func() interface{} { return new([10]uint16) },
+	func() interface{} { return new([]*uint16) },
+	func() interface{} { return new([1]*uint16) },
+	func() interface{} { return new([10]*uint16) },
+	func() interface{} { return new([]uint32) },
+	func() interface{} { return new([1]uint32) },
+	func() interface{} { return new([10]uint32) },
+	func() interface{} { return new([]*uint32) },
+	func() interface{} { return new([1]*uint32) },
+	func() interface{} { return new([10]*uint32) },
+	func() interface{} { return new([]uint64) },
+	func() interface{} { return new([1]uint64) },
+	func() interface{} { return new([10]uint64) },
+	func() interface{} { return new([]*uint64) },
+	func() interface{} { return new([1]*uint64) },
+	func() interface{} { return new([10]*uint64) },
+	func() interface{} { return new([]int) },
+	func() interface{} { return new([1]int) },
+	func() interface{} { return new([10]int) },
+	func() interface{} { return new([]*int) },
+	func() interface{} { return new([1]*int) },
+	func() interface{} { return new([10]*int) },
+	func() interface{} { return new([]int8) },
+	func() interface{} { return new([1]int8) },
+	func() interface{} { return new([10]int8) },
+	func() interface{} { return new([]*int8) },
+	func() interface{} { return new([1]*int8) },
+	func() interface{} { return new([10]*int8) },
+	func() interface{} { return new([]int16) },
+	func() interface{} { return new([1]int16) },
+	func() interface{} { return new([10]int16) },
+	func() interface{} { return new([]*int16) },
+	func() interface{} { return new([1]*int16) },
+	func() interface{} { return new([10]*int16) },
+	func() interface{} { return new([]int32) },
+	func() interface{} { return new([1]int32) },
+	func() interface{} { return new([10]int32) },
+	func() interface{} { return new([]*int32) },
+	func() interface{} { return new([1]*int32) },
+	func() interface{} { return new([10]*int32) },
+	func() interface{} { return new([]int64) },
+	func() interface{} { return new([1]int64) },
+	func() interface{} { return new([10]int64) },
+	func() interface{} { return new([]*int64) },
+	func() interface{} { return new([1]*int64) },
+	func() interface{} { return new([10]*int64) },
+	func() interface{} { return new([]float32) },
+	func() interface{} { return new([1]float32) },
+	func() interface{} { return new([10]float32) },
+	func() interface{} { return new([]*float32) },
+	func() interface{} { return new([1]*float32) },
+	func() interface{} { return new([10]*float32) },
+	func() interface{} { return new([]float64) },
+	func() interface{} { return new([1]float64) },
+	func() interface{} { return new([10]float64) },
+	func() interface{} { return new([]*float64) },
+	func() interface{} { return new([1]*float64) },
+	func() interface{} { return new([10]*float64) },
+	func() interface{} { return new([]string) },
+	func() interface{} { return new([1]string) },
+	func() interface{} { return new([10]string) },
+	func() interface{} { return new([]*string) },
+	func() interface{} { return new([1]*string) },
+	func() interface{} { return new([10]*string) },
+	func() interface{} { return new(map[interface{}]interface{}) },
+	func() interface{} { return new(map[int]interface{}) },
+	func() interface{} { return new(map[string]interface{}) },
+	func() interface{} { return new(map[int]int) },
+	func() interface{} { return new(map[int]*int) },
+	func() interface{} { return new(map[string]string) },
+	func() interface{} { return new(map[string]*string) },
+	func() interface{} { return new(cbor.RawMessage) },
+	func() interface{} { return new(cbor.Tag) },
+	func() interface{} { return new(cbor.RawTag) },
+	func() interface{} { return new(cbor.SimpleValue) },
+	func() interface{} { return new([]cbor.SimpleValue) },
+	func() interface{} { return new(map[cbor.SimpleValue]interface{}) },
+	func() interface{} { return new(marshaller) },
+	func() interface{} { return new(time.Time) },
+	func() interface{} { return new(big.Int) },
+	func() interface{} { return new(claims) },
+	func() interface{} { return new(signedCWT) },
+	func() interface{} { return new(nestedCWT) },
+	func() interface{} { return new(coseSign1) },
+	func() interface{} { return new(coseSign) },
+	func() interface{} { return new(coseMac0) },
+	func() interface{} { return new(coseMac) },
+	func() interface{} { return new(coseEncrypt0) },
+	func() interface{} { return new(coseEncrypt) },
+	func() interface{} { return new(coseKey) },
+	func() interface{} { return new(attestationObject) },
+	func() interface{} { return new(ctap2MakeCredentialRequest) },
+	func() interface{} { return new(ctap2MakeCredentialResponse) },
+	func() interface{} { return new(ctap2GetAssertionRequest) },
+	func() interface{} { return new(ctap2GetAssertionResponse) },
+	func() interface{} { return new(ctap2ClientPINRequest) },
+	func() interface{} { return new(ctap2ClientPINResponse) },
+	func() interface{} { return new(t1) },
+	func() interface{} { return new(t2) },
+	func() interface{} { return new(t3) },
+	func() interface{} { return new(treeNode) },
+	func() interface{} { return new(treeArrayNode) },
+	func() interface{} { return new(listNode) },
+	func() interface{} { return new(nestedStruct) },
+}
+
 // Fuzz decodes->encodes->decodes CBOR data into different Go types and
 // compares the results.
 func Fuzz(data []byte) int {
+	return fuzz(data, newEmbeddedBudget())
+}
+
+// fuzz is Fuzz with budget for fuzzing CBOR data embedded in byte strings of data.
+func fuzz(data []byte, budget *embeddedBudget) int {
 	score := 0
-	for _, ctor := range []func() interface{}{
-		func() interface{} { return nil },
-		func() interface{} { return new(interface{}) },
-		func() interface{} { return new(bool) },
-		func() interface{} { return new(uint) },
-		func() interface{} { return new(uint8) },
-		func() interface{} { return new(uint16) },
-		func() interface{} { return new(uint32) },
-		func() interface{} { return new(uint64) },
-		func() interface{} { return new(int) },
-		func() interface{} { return new(int8) },
-		func() interface{} { return new(int16) },
-		func() interface{} { return new(int32) },
-		func() interface{} { return new(int64) },
-		func() interface{} { return new(float32) },
-		func() interface{} { return new(float64) },
-		func() interface{} { return new(string) },
-		func() interface{} { return new([]byte) },
-		func() interface{} { return new([1]byte) },
-		func() interface{} { return new([10]byte) },
-		func() interface{} { return new([]interface{}) },
-		func() interface{} { return new([1]interface{}) },
-		func() interface{} { return new([10]interface{}) },
-		func() interface{} { return new([]bool) },
-		func() interface{} { return new([1]bool) },
-		func() interface{} { return new([10]bool) },
-		func() interface{} { return new([]*bool) },
-		func() interface{} { return new([1]*bool) },
-		func() interface{} { return new([10]*bool) },
-		func() interface{} { return new([]uint) },
-		func() interface{} { return new([1]uint) },
-		func() interface{} { return new([10]uint) },
-		func() interface{} { return new([]*uint) },
-		func() interface{} { return new([1]*uint) },
-		func() interface{} { return new([10]*uint) },
-		func() interface{} { return new([]uint8) },
-		func() interface{} { return new([1]uint8) },
-		func() interface{} { return new([10]uint8) },
-		func() interface{} { return new([]*uint8) },
-		func() interface{} { return new([1]*uint8) },
-		func() interface{} { return new([10]*uint8) },
-		func() interface{} { return new([]uint16) },
-		func() interface{} { return new([1]uint16) },
-		func() interface{} { return new([10]uint16) },
-		func() interface{} { return new([]*uint16) },
-		func() interface{} { return new([1]*uint16) },
-		func() interface{} { return new([10]*uint16) },
-		func() interface{} { return new([]uint32) },
-		func() interface{} { return new([1]uint32) },
-		func() interface{} { return new([10]uint32) },
-		func() interface{} { return new([]*uint32) },
-		func() interface{} { return new([1]*uint32) },
-		func() interface{} { return new([10]*uint32) },
-		func() interface{} { return new([]uint64) },
-		func() interface{} { return new([1]uint64) },
-		func() interface{} { return new([10]uint64) },
-		func() interface{} { return new([]*uint64) },
-		func() interface{} { return new([1]*uint64) },
-		func() interface{} { return new([10]*uint64) },
-		func() interface{} { return new([]int) },
-		func() interface{} { return new([1]int) },
-		func() interface{} { return new([10]int) },
-		func() interface{} { return new([]*int) },
-		func() interface{} { return new([1]*int) },
-		func() interface{} { return new([10]*int) },
-		func() interface{} { return new([]int8) },
-		func() interface{} { return new([1]int8) },
-		func() interface{} { return new([10]int8) },
-		func() interface{} { return new([]*int8) },
-		func() interface{} { return new([1]*int8) },
-		func() interface{} { return new([10]*int8) },
-		func() interface{} { return new([]int16) },
-		func() interface{} { return new([1]int16) },
-		func() interface{} { return new([10]int16) },
-		func() interface{} { return new([]*int16) },
-		func() interface{} { return new([1]*int16) },
-		func() interface{} { return new([10]*int16) },
-		func() interface{} { return new([]int32) },
-		func() interface{} { return new([1]int32) },
-		func() interface{} { return new([10]int32) },
-		func() interface{} { return new([]*int32) },
-		func() interface{} { return new([1]*int32) },
-		func() interface{} { return new([10]*int32) },
-		func() interface{} { return new([]int64) },
-		func() interface{} { return new([1]int64) },
-		func() interface{} { return new([10]int64) },
-		func() interface{} { return new([]*int64) },
-		func() interface{} { return new([1]*int64) },
-		func() interface{} { return new([10]*int64) },
-		func() interface{} { return new([]float32) },
-		func() interface{} { return new([1]float32) },
-		func() interface{} { return new([10]float32) },
-		func() interface{} { return new([]*float32) },
-		func() interface{} { return new([1]*float32) },
-		func() interface{} { return new([10]*float32) },
-		func() interface{} { return new([]float64) },
-		func() interface{} { return new([1]float64) },
-		func() interface{} { return new([10]float64) },
-		func() interface{} { return new([]*float64) },
-		func() interface{} { return new([1]*float64) },
-		func() interface{} { return new([10]*float64) },
-		func() interface{} { return new([]string) },
-		func() interface{} { return new([1]string) },
-		func() interface{} { return new([10]string) },
-		func() interface{} { return new([]*string) },
-		func() interface{} { return new([1]*string) },
-		func() interface{} { return new([10]*string) },
-		func() interface{} { return new(map[interface{}]interface{}) },
-		func() interface{} { return new(map[int]interface{}) },
-		func() interface{} { return new(map[string]interface{}) },
-		func() interface{} { return new(map[int]int) },
-		func() interface{} { return new(map[int]*int) },
-		func() interface{} { return new(map[string]string) },
-		func() interface{} { return new(map[string]*string) },
-		func() interface{} { return new(cbor.RawMessage) },
-		func() interface{} { return new(cbor.Tag) },
-		func() interface{} { return new(cbor.RawTag) },
-		func() interface{} { return new(marshaller) },
-		func() interface{} { return new(time.Time) },
-		func() interface{} { return new(big.Int) },
-		func() interface{} { return new(claims) },
-		func() interface{} { return new(signedCWT) },
-		func() interface{} { return new(nestedCWT) },
-		func() interface{} { return new(coseKey) },
-		func() interface{} { return new(attestationObject) },
-		func() interface{} { return new(t1) },
-		func() interface{} { return new(t2) },
-		func() interface{} { return new(t3) },
-	} {
+
+	// Parse data with reference parser, which doesn't use the library.
+	item, _, itemErr := parseDataItem(data)
+	if itemErr == nil {
+		// Decode CBOR maps with keys that can't be Go map keys.
+		fuzzUnhashableMapKey(data, item)
+	}
+
+	// Decode CBOR simple values, including reserved simple values.
+	fuzzSimpleValue(data, item)
+
+	if itemErr == nil {
+		// Decode to recursive types with default and tight MaxNestedLevels.
+		fuzzNestedLevels(data, item)
+
+		// Decode CBOR integers to Go integer types and interface{} with every IntDec option.
+		fuzzIntegerRange(item)
+
+		// Validate CBOR text strings and decode them with each UTF8 option.
+		fuzzUTF8(data, item)
+
+		// Find duplicate map keys independently and decode with DupMapKeyEnforcedAPF.
+		fuzzDuplicateMapKey(item)
+
+		// Decode tag 0 and 1 to time.Time with independent RFC 3339 and epoch time parser.
+		fuzzTimeTag(item)
+
+		// Decode tag 2 and 3 with every BigIntDec option and compare with bignum model.
+		fuzzBignum(item)
+
+		// Decode COSE messages with registered tag numbers.
+		fuzzCOSE(data, item)
+
+		// Decode CWT payload to claims, including nested CWTs.
+		fuzzCWT(item, 1)
+
+		// Verify COSE_Sign1 and COSE_Mac0 with known keys before and after re-encoding.
+		fuzzCOSEVerify(item)
+
+		// Decrypt COSE_Encrypt0 with known keys and compare plaintext with known claims.
+		fuzzCOSEDecrypt(item, 1)
+
+		// Decode CTAP2 messages with options rejecting non-canonical data, and check CTAP2 encoding.
+		fuzzCTAP2(data, item)
+
+		// Decode standard tags with registered semantic types and compare with tag models.
+		fuzzSemanticTag(item)
+
+		// Fuzz CBOR data embedded in byte strings recursively, and round trip tag 24.
+		fuzzEmbedded(item, budget)
+	}
+
+	// Go floats are decoded only from data with numbers or simple values.
+	floatSource := itemErr == nil && hasFloatSource(item)
+
+	for i, ctor := range ctors {
 		// Decode with default options
 		v1 := ctor()
 		dec := cbor.NewDecoder(bytes.NewReader(data))
@@ -269,6 +396,13 @@ func Fuzz(data []byte) int {
 		}
 		score = 1
 
+		if itemErr != nil {
+			panic(fmt.Sprintf("decoded malformed CBOR data 0x%x: %v", data, itemErr))
+		}
+
+		// Decode to non-zero value and compare result with documented rules.
+		fuzzDecodeToNonZeroValue(data, item, i, ctor, v1)
+
 		// Decode with IntDec set to IntDecConvertSigned.
 		fuzzIntDecoding(data, ctor())
 
@@ -276,7 +410,21 @@ func Fuzz(data []byte) int {
 		fuzzDuplicateMapKeyDecoding(data, ctor())
 
 		// Decode with ExtraReturnErrors set to ExtraDecErrorUnknownField.
-		fuzzUnknownField(data, ctor())
+		fuzzUnknownField(data, item, ctor())
+
+		// Encode floats with different float options and compare results with float encoding model.
+		if floatSource {
+			fuzzFloat(v1)
+		}
+
+		switch v := v1.(type) {
+		case *attestationObject:
+			// Parse authenticator data with COSE_Key decoded by UnmarshalFirst.
+			fuzzAuthenticatorData(v.AuthnData)
+		case *coseKey:
+			// Decode COSE_Key strictly to key type picked by kty.
+			fuzzCOSEKey(data, item, v.Kty)
+		}
 
 		switch v := v1.(type) {
 		case *time.Time:
@@ -287,41 +435,68 @@ func Fuzz(data []byte) int {
 			continue
 		}
 
+		// RawMessage and RawTag are encoded as is, so encoded data isn't checked for them.
+		checkEncoded := !hasType(reflect.ValueOf(v1), typeRawMessage) && !hasType(reflect.ValueOf(v1), typeRawTag)
+
+		// Distinct time.Time map keys can be encoded to the same CBOR data, so map keys aren't
+		// sorted strictly.
+		checkSorted := checkEncoded && !hasRoundTripEqualMapKeys(reflect.ValueOf(v1))
+
 		// Encode with default options
-		enc := cbor.NewEncoder(ioutil.Discard)
+		var encoded bytes.Buffer
+		enc := cbor.NewEncoder(&encoded)
 		if err := enc.Encode(v1); err != nil {
 			panic(err)
 		}
+		if checkEncoded {
+			checkEncodedUTF8(encoded.Bytes(), "Default")
+		}
 
 		// Encode with "Preferred" encoding options
-		enc = emPreferred.NewEncoder(ioutil.Discard)
+		encoded.Reset()
+		enc = emPreferred.NewEncoder(&encoded)
 		if err := enc.Encode(v1); err != nil {
 			panic(err)
 		}
+		if checkEncoded {
+			checkEncodedUTF8(encoded.Bytes(), "Preferred")
+		}
 
 		// Encode with "Canonical" encoding options
-		enc = emCanonical.NewEncoder(ioutil.Discard)
+		encoded.Reset()
+		enc = emCanonical.NewEncoder(&encoded)
 		if err := enc.Encode(v1); err != nil {
 			panic(err)
 		}
-
-		// Encode with "CTAP2 Canonical" encoding options (TagsAllowed is needed to avoid error when encoding CBOR tags)
-		ctap2Opts := cbor.CTAP2EncOptions()
-		ctap2Opts.TagsMd = cbor.TagsAllowed
-		emCTAP2, err := ctap2Opts.EncMode()
-		if err != nil {
-			panic(err)
+		if checkSorted {
+			checkSortedEncoding(encoded.Bytes(), "Canonical", emCanonical, lengthFirstKeyLess)
+		}
+		if checkEncoded {
+			checkEncodedUTF8(encoded.Bytes(), "Canonical")
 		}
-		enc = emCTAP2.NewEncoder(ioutil.Discard)
+
+		// Encode with "CTAP2 Canonical" encoding options
+		encoded.Reset()
+		enc = emCTAP2TagsAllowed.NewEncoder(&encoded)
 		if err := enc.Encode(v1); err != nil {
 			panic(err)
 		}
+		if checkSorted {
+			checkSortedEncoding(encoded.Bytes(), "CTAP2 Canonical", emCTAP2TagsAllowed, ctap2KeyLess)
+		}
+		if checkEncoded {
+			checkEncodedUTF8(encoded.Bytes(), "CTAP2 Canonical")
+		}
 
 		// Encode with BigIntConvert set to BigIntConvertNone (encode big.Int as CBOR tag 2/3)
-		enc = emBigIntConvertNone.NewEncoder(ioutil.Discard)
+		encoded.Reset()
+		enc = emBigIntConvertNone.NewEncoder(&encoded)
 		if err := enc.Encode(v1); err != nil {
 			panic(err)
 		}
+		if checkEncoded {
+			checkEncodedUTF8(encoded.Bytes(), "BigIntConvertNone")
+		}
 
 		// Encode with "Core Deterministic" encoding options
 		var buf bytes.Buffer
@@ -329,10 +504,16 @@ func Fuzz(data []byte) int {
 		if err := enc.Encode(v1); err != nil {
 			panic(err)
 		}
+		if checkSorted {
+			checkSortedEncoding(buf.Bytes(), "Core Deterministic", emCoreDeterministic, bytewiseKeyLess)
+		}
+		if checkEncoded {
+			checkEncodedUTF8(buf.Bytes(), "Core Deterministic")
+		}
 
 		v2 := ctor()
 		dec = cbor.NewDecoder(&buf)
-		if dec.Decode(v2) != nil {
+		if err := dec.Decode(v2); err != nil {
 			panic(err)
 		}
 
@@ -354,8 +535,7 @@ func Fuzz(data []byte) int {
 			}
 		}
 
-		// Skip equal test for objects with time.Time or big.Int as an element
-		if !hasType(reflect.ValueOf(v1), typeTime) && !hasType(reflect.ValueOf(v1), typeBigInt) && !DeepEqual(v1, v2) {
+		if !roundTripEqual(v1, v2) {
 			rv1, rv2 := reflect.ValueOf(v1), reflect.ValueOf(v2)
 			for rv1.Kind() == reflect.Ptr || rv1.Kind() == reflect.Interface {
 				rv1 = rv1.Elem()
@@ -387,72 +567,195 @@ func fuzzIntDecoding(data []byte, v interface{}) {
 	}
 }
 
-func fuzzUnknownField(data []byte, v interface{}) {
+// fuzzUnknownField decodes data to v with ExtraDecErrorUnknownField, and checks that
+// UnknownFieldError is returned exactly when CBOR map has a key not matching struct fields.
+func fuzzUnknownField(data []byte, item *dataItem, v interface{}) {
 	dec := dmExtraErrorUnknownField.NewDecoder(bytes.NewReader(data))
-	if err := dec.Decode(v); err != nil {
+	err := dec.Decode(v)
+	if err != nil {
 		if _, ok := err.(*cbor.UnknownFieldError); !ok {
 			panic(err)
 		}
 	}
-}
 
-func fuzzTime(t *time.Time) {
-	// Fuzz unix time with second precision.
-	var b1 bytes.Buffer
-	enc := emTimeUnix.NewEncoder(&b1)
-	if err := enc.Encode(t); err != nil {
-		panic(err)
+	t := reflect.TypeOf(v)
+	if t.Kind() != reflect.Ptr || t.Elem().Kind() != reflect.Struct || t.Elem() == typeTime || t.Elem() == typeBigInt || t.Elem() == typeTag || t.Elem() == typeRawTag {
+		return
 	}
-	var t1 time.Time
-	dec := cbor.NewDecoder(&b1)
-	if err := dec.Decode(&t1); err != nil {
-		panic(err)
+	// Tag numbers are ignored when decoding to struct.
+	for item.major == majorTypeTag {
+		item = item.tagContent()
+	}
+	flds, toArray := structFields(t.Elem())
+	if item.major != majorTypeMap || toArray {
+		return
 	}
 
-	// Fuzz unix time with microsecond precision.
-	b1.Reset()
-	enc = emTimeUnixMicro.NewEncoder(&b1)
-	if err := enc.Encode(t); err != nil {
-		panic(err)
+	index := unknownField(flds, item)
+	if index >= 0 {
+		if e, ok := err.(*cbor.UnknownFieldError); !ok || e.Index != index {
+			panic(fmt.Sprintf("decoding 0x%x to %s returned %v, want UnknownFieldError at index %d", data, t, err, index))
+		}
+		return
 	}
-	dec = cbor.NewDecoder(&b1)
-	if err := dec.Decode(&t1); err != nil {
-		panic(err)
+	if err == nil {
+		return
 	}
 
-	// Fuzz unix time with second/microsecond precision.
-	b1.Reset()
-	enc = emTimeUnixDynamic.NewEncoder(&b1)
-	if err := enc.Encode(t); err != nil {
-		panic(err)
+	// UnknownFieldError without unknown field in this map must be from field value.
+	e := err.(*cbor.UnknownFieldError)
+	for j, i := range matchStructFields(flds, item) {
+		if i < 0 {
+			continue
+		}
+		fv := reflect.New(t.Elem().Field(flds[i].idx).Type)
+		fe, ok := dmExtraErrorUnknownField.Unmarshal(item.value(j).raw, fv.Interface()).(*cbor.UnknownFieldError)
+		if ok && fe.Index == e.Index {
+			return
+		}
 	}
-	dec = cbor.NewDecoder(&b1)
-	if err := dec.Decode(&t1); err != nil {
-		panic(err)
+	panic(fmt.Sprintf("decoding 0x%x without unknown field to %s returned %v", data, t, err))
+}
+
+// timeEncModes are time encoding modes without and with TimeTag set to EncTagRequired.
+// timeModel returns time decoded from encoded t with max difference caused by float
+// conversion, and false if decoded time can't be compared.
+var timeEncModes = []struct {
+	name      string
+	em, emTag cbor.EncMode
+	tagNum    byte
+	timeModel func(t time.Time) (time.Time, time.Duration, bool)
+}{
+	{"TimeUnix", emTimeUnix, emTimeUnixTag, 1, func(t time.Time) (time.Time, time.Duration, bool) {
+		// Time is truncated to seconds.
+		return time.Unix(t.Unix(), 0), 0, true
+	}},
+	{"TimeUnixMicro", emTimeUnixMicro, emTimeUnixMicroTag, 1, func(t time.Time) (time.Time, time.Duration, bool) {
+		// Time is rounded to microseconds and encoded as float of UnixNano, which
+		// overflows int64 outside of years 1678 to 2261.
+		if t.Year() < 1678 || t.Year() > 2261 {
+			return t, 0, false
+		}
+		t = t.Round(time.Microsecond)
+		return t, floatTimeTolerance(t), true
+	}},
+	{"TimeUnixDynamic", emTimeUnixDynamic, emTimeUnixDynamicTag, 1, func(t time.Time) (time.Time, time.Duration, bool) {
+		// Time is rounded to microseconds, and encoded as float if it has fraction of second.
+		t = t.Round(time.Microsecond)
+		if t.Nanosecond() == 0 {
+			return t, 0, true
+		}
+		if secs := t.Unix(); secs >= 1<<53 || secs <= -1<<53 {
+			return t, 0, false
+		}
+		return t, floatTimeTolerance(t), true
+	}},
+	{"TimeRFC3339", emTimeRFC3339, emTimeRFC3339Tag, 0, func(t time.Time) (time.Time, time.Duration, bool) {
+		// Fraction of second is dropped.
+		return t.Add(-time.Duration(t.Nanosecond())), 0, true
+	}},
+	{"TimeRFC3339Nano", emTimeRFC3339Nano, emTimeRFC3339NanoTag, 0, func(t time.Time) (time.Time, time.Duration, bool) {
+		return t, 0, true
+	}},
+}
+
+// floatTimeTolerance returns max difference between t and time decoded from t encoded
+// as float64 seconds, which has 52-bit mantissa.
+func floatTimeTolerance(t time.Time) time.Duration {
+	secs := math.Abs(float64(t.Unix())) + 1
+	return time.Duration(secs*4*1e9/(1<<52)) + 2
+}
+
+// monoReferenceTime is the center of times checked with monotonic clock reading by fuzzTime,
+// within maxMonoOffset.
+var monoReferenceTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+const maxMonoOffset = 100 * 365 * 24 * time.Hour
+
+func fuzzTime(t *time.Time) {
+	// Zero time is encoded as CBOR null, even if tag number is required.
+	if t.IsZero() {
+		for _, m := range timeEncModes {
+			for _, em := range []cbor.EncMode{m.em, m.emTag} {
+				b, err := em.Marshal(t)
+				if err != nil {
+					panic(err)
+				}
+				if !bytes.Equal(b, []byte{0xf6}) {
+					panic(fmt.Sprintf("%s encoding zero time returned 0x%x, want 0xf6", m.name, b))
+				}
+			}
+		}
+		return
 	}
 
-	if t.Year() >= 0 && t.Year() < 10000 {
-		// Fuzz time in RFC3339 format.
-		var b2 bytes.Buffer
-		enc = emTimeRFC3339.NewEncoder(&b2)
-		if err := enc.Encode(t); err != nil {
-			panic(err)
+	// Time with monotonic clock reading and local location.  Monotonic clock reading is only
+	// available from time.Now, so it's added to times near monoReferenceTime, which is fixed to
+	// keep the check independent of the wall clock.
+	var tMono time.Time
+	hasMono := false
+	if d := t.Sub(monoReferenceTime); d > -maxMonoOffset && d < maxMonoOffset {
+		now := time.Now()
+		tMono, hasMono = now.Add(t.Sub(now)), true
+	}
+
+	for _, m := range timeEncModes {
+		rfc3339 := m.tagNum == 0
+		if rfc3339 && (t.Year() < 0 || t.Year() >= 10000) {
+			continue
 		}
-		var t2 time.Time
-		dec = cbor.NewDecoder(&b2)
-		if err := dec.Decode(&t2); err != nil {
+
+		b, err := m.em.Marshal(t)
+		if err != nil {
 			panic(err)
 		}
 
-		// Fuzz time in RFC3339 nano format.
-		b2.Reset()
-		enc = emTimeRFC3339Nano.NewEncoder(&b2)
-		if err := enc.Encode(t); err != nil {
+		// TimeTag EncTagRequired adds tag number 0 or 1 to the same content.
+		bTag, err := m.emTag.Marshal(t)
+		if err != nil {
 			panic(err)
 		}
-		dec = cbor.NewDecoder(&b2)
-		if err := dec.Decode(&t2); err != nil {
-			panic(err)
+		if !bytes.Equal(bTag, append([]byte{0xc0 | m.tagNum}, b...)) {
+			panic(fmt.Sprintf("%s encoding %v with tag returned 0x%x, want tag %d and 0x%x", m.name, t, bTag, m.tagNum, b))
+		}
+
+		// Location is stripped by Unix time encodings, and monotonic clock reading is stripped by all encodings.
+		if !rfc3339 {
+			for _, loc := range []*time.Location{time.UTC, time.FixedZone("UTC+1", 3600), time.FixedZone("UTC-12:30", -45000)} {
+				if b1, err := m.em.Marshal(t.In(loc)); err != nil || !bytes.Equal(b1, b) {
+					panic(fmt.Sprintf("%s encoding %v in %s returned 0x%x, want 0x%x", m.name, t, loc, b1, b))
+				}
+			}
+		}
+		if hasMono {
+			b1, err := m.em.Marshal(tMono)
+			if err != nil {
+				panic(err)
+			}
+			b2, err := m.em.Marshal(t.In(time.Local))
+			if err != nil {
+				panic(err)
+			}
+			if !bytes.Equal(b1, b2) {
+				panic(fmt.Sprintf("%s encoding %v with monotonic clock reading returned 0x%x, want 0x%x", m.name, tMono, b1, b2))
+			}
+		}
+
+		want, tolerance, ok := m.timeModel(*t)
+		for _, data := range [][]byte{b, bTag} {
+			var t1 time.Time
+			if err := cbor.Unmarshal(data, &t1); err != nil {
+				panic(err)
+			}
+			if t1 != t1.Round(0) {
+				panic(fmt.Sprintf("decoding 0x%x returned time with monotonic clock reading %v", data, t1))
+			}
+			if !ok {
+				continue
+			}
+			if d := t1.Sub(want); d > tolerance || d < -tolerance || (tolerance == 0 && !t1.Equal(want)) {
+				panic(fmt.Sprintf("%s encoding %v round tripped to %v, want %v", m.name, t, t1, want))
+			}
 		}
 	}
 }
@@ -501,6 +804,19 @@ func fuzzBigInt(bi *big.Int) {
 	if bi.Cmp(&bi2) != 0 {
 		panic(fmt.Sprintf("not equal: v1 %v (big.Int), v2 %v (big.Int)", bi, bi2))
 	}
+
+	// Decode CBOR tag 2/3 data to interface{} with every BigIntDec option.
+	for _, mode := range bigIntDecModes {
+		var v interface{}
+		if err := mode.dm.Unmarshal(bib, &v); err != nil {
+			panic(err)
+		}
+		checkBigIntDec(bib, v, mode.bigIntDec, func(bi3 *big.Int) {
+			if bi.Cmp(bi3) != 0 {
+				panic(fmt.Sprintf("not equal: v1 %v (big.Int), v2 %v (big.Int)", bi, bi3))
+			}
+		})
+	}
 }
 
 func hasType(rv reflect.Value, rt reflect.Type) bool {
@@ -0,0 +1,10 @@
+module github.com/fxamacker/cbor-fuzz
+
+go 1.17
+
+require (
+	github.com/fxamacker/cbor v1.5.1
+	github.com/fxamacker/cbor/v2 v2.6.0
+)
+
+require github.com/x448/float16 v0.8.4 // indirect
@@ -0,0 +1,170 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxFuzzIntegers is the max number of distinct CBOR integers in data checked by
+// fuzzIntegerRange.
+const maxFuzzIntegers = 4
+
+var integerTypes = []reflect.Type{
+	reflect.TypeOf(uint(0)),
+	reflect.TypeOf(uint8(0)),
+	reflect.TypeOf(uint16(0)),
+	reflect.TypeOf(uint32(0)),
+	reflect.TypeOf(uint64(0)),
+	reflect.TypeOf(int(0)),
+	reflect.TypeOf(int8(0)),
+	reflect.TypeOf(int16(0)),
+	reflect.TypeOf(int32(0)),
+	reflect.TypeOf(int64(0)),
+}
+
+var (
+	dmIntDecConvertNone, _           = cbor.DecOptions{IntDec: cbor.IntDecConvertNone}.DecMode()
+	dmIntDecConvertSignedOrFail, _   = cbor.DecOptions{IntDec: cbor.IntDecConvertSignedOrFail}.DecMode()
+	dmIntDecConvertSignedOrBigInt, _ = cbor.DecOptions{IntDec: cbor.IntDecConvertSignedOrBigInt}.DecMode()
+)
+
+// intDecModes are decoding modes with every IntDec option.
+var intDecModes = []struct {
+	intDec cbor.IntDecMode
+	dm     cbor.DecMode
+}{
+	{cbor.IntDecConvertNone, dmIntDecConvertNone},
+	{cbor.IntDecConvertSigned, dmIntDecConvertSigned},
+	{cbor.IntDecConvertSignedOrFail, dmIntDecConvertSignedOrFail},
+	{cbor.IntDecConvertSignedOrBigInt, dmIntDecConvertSignedOrBigInt},
+}
+
+// fuzzIntegerRange decodes distinct CBOR integers in item to Go integer types and their
+// slice, array, and pointer forms, and to interface{}, with every IntDec option.  Results are
+// compared with big.Int value of CBOR integer.
+func fuzzIntegerRange(item *dataItem) {
+	seen := make(map[string]bool)
+	item.walk(func(it *dataItem) {
+		if len(seen) >= maxFuzzIntegers || (it.major != majorTypePositiveInt && it.major != majorTypeNegativeInt) || seen[string(it.raw)] {
+			return
+		}
+		seen[string(it.raw)] = true
+		n := len(seen)
+
+		bi := it.bigInt()
+		array := append(appendHead(nil, majorTypeArray, 1), it.raw...)
+
+		for i, mode := range intDecModes {
+			// IntDec option doesn't affect decoding to Go integer types, so each integer is
+			// decoded to them with one mode, and to one of pointer, slice, and array forms,
+			// rotated across integers.
+			for k, t := range integerTypes {
+				if i != n%len(intDecModes) {
+					break
+				}
+				want, fits := integerModel(bi, t)
+				for j, form := range []struct {
+					t    reflect.Type
+					data []byte
+					want func() reflect.Value
+				}{
+					{t, it.raw, func() reflect.Value { return want }},
+					{reflect.PtrTo(t), it.raw, func() reflect.Value {
+						p := reflect.New(t)
+						p.Elem().Set(want)
+						return p
+					}},
+					{reflect.SliceOf(t), array, func() reflect.Value {
+						s := reflect.MakeSlice(reflect.SliceOf(t), 1, 1)
+						s.Index(0).Set(want)
+						return s
+					}},
+					{reflect.ArrayOf(1, t), array, func() reflect.Value {
+						a := reflect.New(reflect.ArrayOf(1, t)).Elem()
+						a.Index(0).Set(want)
+						return a
+					}},
+				} {
+					if j > 0 && j != 1+(n+k)%3 {
+						continue
+					}
+					v := reflect.New(form.t)
+					err := mode.dm.NewDecoder(bytes.NewReader(form.data)).Decode(v.Interface())
+					if !fits {
+						if _, ok := err.(*cbor.UnmarshalTypeError); !ok {
+							panic(fmt.Sprintf("decoding 0x%x (%s) to %s with IntDec %d returned %v, want UnmarshalTypeError", form.data, bi, form.t, mode.intDec, err))
+						}
+						continue
+					}
+					if err != nil {
+						panic(fmt.Sprintf("decoding 0x%x (%s) to %s with IntDec %d returned %v", form.data, bi, form.t, mode.intDec, err))
+					}
+					if w := form.want(); !DeepEqual(v.Elem().Interface(), w.Interface()) {
+						panic(fmt.Sprintf("decoding 0x%x (%s) to %s with IntDec %d returned %v, want %v", form.data, bi, form.t, mode.intDec, v.Elem(), w))
+					}
+				}
+			}
+
+			// Decode to interface{}.
+			var v interface{}
+			err := mode.dm.NewDecoder(bytes.NewReader(it.raw)).Decode(&v)
+			want, ok := interfaceIntegerModel(bi, it.major, mode.intDec)
+			if !ok {
+				if _, ok := err.(*cbor.UnmarshalTypeError); !ok {
+					panic(fmt.Sprintf("decoding 0x%x (%s) to interface{} with IntDec %d returned %v, want UnmarshalTypeError", it.raw, bi, mode.intDec, err))
+				}
+				continue
+			}
+			if err != nil {
+				panic(fmt.Sprintf("decoding 0x%x (%s) to interface{} with IntDec %d returned %v", it.raw, bi, mode.intDec, err))
+			}
+			if !DeepEqual(v, want) {
+				panic(fmt.Sprintf("decoding 0x%x (%s) to interface{} with IntDec %d returned %v (%T), want %v (%T)", it.raw, bi, mode.intDec, v, v, want, want))
+			}
+		}
+	})
+}
+
+// integerModel returns bi as Go integer type t, and false if bi overflows t.
+func integerModel(bi *big.Int, t reflect.Type) (reflect.Value, bool) {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if bi.Sign() < 0 || !bi.IsUint64() || v.OverflowUint(bi.Uint64()) {
+			return v, false
+		}
+		v.SetUint(bi.Uint64())
+	default:
+		if !bi.IsInt64() || v.OverflowInt(bi.Int64()) {
+			return v, false
+		}
+		v.SetInt(bi.Int64())
+	}
+	return v, true
+}
+
+// interfaceIntegerModel returns Go value that CBOR integer bi is decoded to in interface{}
+// with intDec option, and false if decoding returns UnmarshalTypeError.
+func interfaceIntegerModel(bi *big.Int, major byte, intDec cbor.IntDecMode) (interface{}, bool) {
+	if bi.IsInt64() && (major == majorTypeNegativeInt || intDec != cbor.IntDecConvertNone) {
+		return bi.Int64(), true
+	}
+	switch {
+	case major == majorTypePositiveInt && intDec == cbor.IntDecConvertNone:
+		return bi.Uint64(), true
+	case intDec == cbor.IntDecConvertSignedOrFail:
+		return nil, false
+	case major == majorTypePositiveInt && intDec == cbor.IntDecConvertSigned:
+		// Positive integer overflowing int64 can't be converted to signed integer.
+		return nil, false
+	}
+	// Integer overflowing int64 is decoded to big.Int.
+	return *bi, true
+}
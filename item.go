@@ -0,0 +1,297 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+)
+
+// CBOR major types.
+const (
+	majorTypePositiveInt byte = iota
+	majorTypeNegativeInt
+	majorTypeByteString
+	majorTypeTextString
+	majorTypeArray
+	majorTypeMap
+	majorTypeTag
+	majorTypePrimitives
+)
+
+// maxDataItemDepth is the max nested level of arrays, maps and tags accepted by parseDataItem.
+const maxDataItemDepth = 1024
+
+var errUnexpectedEOF = errors.New("reference: unexpected EOF")
+
+// dataItem is a CBOR data item parsed without using the library under test.
+// It is the reference data-item tree that decoded values are checked against.
+type dataItem struct {
+	major   byte        // major type
+	ai      byte        // additional information
+	val     uint64      // integer value, length, tag number, simple value, or float bits
+	indef   bool        // true if item is indefinite length
+	headLen int         // number of bytes in head
+	raw     []byte      // encoded data item, including head
+	content []byte      // byte/text string content, with chunks concatenated
+	chunks  []*dataItem // chunks of indefinite length byte/text string
+	items   []*dataItem // array elements, map keys and values interleaved, or tag content
+}
+
+// parseDataItem parses the first well-formed CBOR data item in data and returns it
+// with remaining bytes.
+func parseDataItem(data []byte) (*dataItem, []byte, error) {
+	item, n, err := parseDataItemAt(data, 0, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	return item, data[n:], nil
+}
+
+func parseDataItemAt(data []byte, off int, depth int) (*dataItem, int, error) {
+	if depth > maxDataItemDepth {
+		return nil, 0, fmt.Errorf("reference: exceeded max nested level %d", maxDataItemDepth)
+	}
+	start := off
+	major, ai, val, off, err := parseHead(data, off)
+	if err != nil {
+		return nil, 0, err
+	}
+	item := &dataItem{major: major, ai: ai, val: val, indef: ai == 31, headLen: off - start}
+
+	switch major {
+	case majorTypeByteString, majorTypeTextString:
+		if item.indef {
+			item.content = []byte{}
+			for {
+				if off >= len(data) {
+					return nil, 0, errUnexpectedEOF
+				}
+				if data[off] == 0xff {
+					off++
+					break
+				}
+				if data[off]>>5 != major || data[off]&0x1f == 31 {
+					return nil, 0, fmt.Errorf("reference: invalid chunk 0x%02x in indefinite length string", data[off])
+				}
+				var chunk *dataItem
+				if chunk, off, err = parseDataItemAt(data, off, depth); err != nil {
+					return nil, 0, err
+				}
+				item.chunks = append(item.chunks, chunk)
+				item.content = append(item.content, chunk.content...)
+			}
+			break
+		}
+		if val > uint64(len(data)-off) {
+			return nil, 0, errUnexpectedEOF
+		}
+		item.content = data[off : off+int(val)]
+		off += int(val)
+
+	case majorTypeArray, majorTypeMap:
+		if item.indef {
+			for {
+				if off >= len(data) {
+					return nil, 0, errUnexpectedEOF
+				}
+				if data[off] == 0xff {
+					off++
+					break
+				}
+				var elem *dataItem
+				if elem, off, err = parseDataItemAt(data, off, depth+1); err != nil {
+					return nil, 0, err
+				}
+				item.items = append(item.items, elem)
+			}
+			if major == majorTypeMap && len(item.items)%2 == 1 {
+				return nil, 0, errors.New("reference: unexpected break code in map")
+			}
+			break
+		}
+		count := val
+		if major == majorTypeMap {
+			if count > uint64(len(data)-off)/2 {
+				return nil, 0, errUnexpectedEOF
+			}
+			count *= 2
+		}
+		if count > uint64(len(data)-off) {
+			return nil, 0, errUnexpectedEOF
+		}
+		item.items = make([]*dataItem, int(count))
+		for i := range item.items {
+			if item.items[i], off, err = parseDataItemAt(data, off, depth+1); err != nil {
+				return nil, 0, err
+			}
+		}
+
+	case majorTypeTag:
+		var content *dataItem
+		if content, off, err = parseDataItemAt(data, off, depth+1); err != nil {
+			return nil, 0, err
+		}
+		item.items = []*dataItem{content}
+	}
+
+	item.raw = data[start:off]
+	return item, off, nil
+}
+
+func parseHead(data []byte, off int) (major byte, ai byte, val uint64, next int, err error) {
+	if off >= len(data) {
+		return 0, 0, 0, 0, errUnexpectedEOF
+	}
+	major, ai = data[off]>>5, data[off]&0x1f
+	off++
+	switch {
+	case ai < 24:
+		val = uint64(ai)
+	case ai <= 27:
+		n := 1 << (ai - 24)
+		if len(data)-off < n {
+			return 0, 0, 0, 0, errUnexpectedEOF
+		}
+		switch n {
+		case 1:
+			val = uint64(data[off])
+		case 2:
+			val = uint64(binary.BigEndian.Uint16(data[off:]))
+		case 4:
+			val = uint64(binary.BigEndian.Uint32(data[off:]))
+		case 8:
+			val = binary.BigEndian.Uint64(data[off:])
+		}
+		off += n
+		if major == majorTypePrimitives && ai == 24 && val < 32 {
+			return 0, 0, 0, 0, fmt.Errorf("reference: invalid simple value %d", val)
+		}
+	case ai == 31:
+		switch major {
+		case majorTypePositiveInt, majorTypeNegativeInt, majorTypeTag:
+			return 0, 0, 0, 0, fmt.Errorf("reference: invalid additional information 31 for major type %d", major)
+		case majorTypePrimitives:
+			return 0, 0, 0, 0, errors.New("reference: unexpected break code")
+		}
+	default:
+		return 0, 0, 0, 0, fmt.Errorf("reference: reserved additional information %d", ai)
+	}
+	return major, ai, val, off, nil
+}
+
+// numPairs returns number of key-value pairs in map item.
+func (item *dataItem) numPairs() int {
+	return len(item.items) / 2
+}
+
+// key returns key of the i-th pair in map item.
+func (item *dataItem) key(i int) *dataItem {
+	return item.items[2*i]
+}
+
+// value returns value of the i-th pair in map item.
+func (item *dataItem) value(i int) *dataItem {
+	return item.items[2*i+1]
+}
+
+// tagContent returns content of tag item.
+func (item *dataItem) tagContent() *dataItem {
+	return item.items[0]
+}
+
+// bigInt returns value of integer item.
+func (item *dataItem) bigInt() *big.Int {
+	bi := new(big.Int).SetUint64(item.val)
+	if item.major == majorTypeNegativeInt {
+		bi.Add(bi, big.NewInt(1))
+		bi.Neg(bi)
+	}
+	return bi
+}
+
+// walk calls fn for item and all items nested inside it, in encoding order.
+// Chunks of indefinite length strings are not visited.
+func (item *dataItem) walk(fn func(*dataItem)) {
+	fn(item)
+	for _, elem := range item.items {
+		elem.walk(fn)
+	}
+}
+
+// appendHead appends CBOR head with major type and argument val in its shortest form.
+func appendHead(b []byte, major byte, val uint64) []byte {
+	switch {
+	case val < 24:
+		return append(b, major<<5|byte(val))
+	case val <= 0xff:
+		return append(b, major<<5|24, byte(val))
+	case val <= 0xffff:
+		return append(b, major<<5|25, byte(val>>8), byte(val))
+	case val <= 0xffffffff:
+		return append(b, major<<5|26, byte(val>>24), byte(val>>16), byte(val>>8), byte(val))
+	}
+	b = append(b, major<<5|27)
+	return append(b, byte(val>>56), byte(val>>48), byte(val>>40), byte(val>>32), byte(val>>24), byte(val>>16), byte(val>>8), byte(val))
+}
+
+// nestedLevel returns max nested level of item, counted the same way as the library's
+// MaxNestedLevels option: an array or map adds a level, and a tag adds a level if its
+// content is also a tag.
+func (item *dataItem) nestedLevel() int {
+	level := 0
+	for _, elem := range item.items {
+		if l := elem.nestedLevel(); l > level {
+			level = l
+		}
+	}
+	switch item.major {
+	case majorTypeArray, majorTypeMap:
+		level++
+	case majorTypeTag:
+		if item.tagContent().major == majorTypeTag {
+			level++
+		}
+	}
+	return level
+}
+
+// float returns value of floating-point item, and false if item isn't floating-point number.
+func (item *dataItem) float() (float64, bool) {
+	if item.major != majorTypePrimitives {
+		return 0, false
+	}
+	switch item.ai {
+	case 25:
+		return float64(float16ToFloat32(uint16(item.val))), true
+	case 26:
+		return float64(math.Float32frombits(uint32(item.val))), true
+	case 27:
+		return math.Float64frombits(item.val), true
+	}
+	return 0, false
+}
+
+// float16ToFloat32 converts IEEE 754 half-precision bits to float32.
+func float16ToFloat32(h uint16) float32 {
+	sign := uint32(h>>15) << 31
+	exp := uint32(h>>10) & 0x1f
+	frac := uint32(h) & 0x3ff
+	switch exp {
+	case 0:
+		// Zero and subnormal numbers.
+		f := float32(frac) / (1 << 24)
+		if sign != 0 {
+			f = -f
+		}
+		return f
+	case 0x1f:
+		// Infinity and NaN, with payload preserved.
+		return math.Float32frombits(sign | 0xff<<23 | frac<<13)
+	}
+	return math.Float32frombits(sign | (exp+127-15)<<23 | frac<<13)
+}
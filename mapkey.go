@@ -0,0 +1,178 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// fuzzUnhashableMapKey decodes CBOR maps with keys that can't be Go map keys (e.g. CBOR
+// arrays and maps), and checks decoding errors and decoded keys against the reference data item.
+func fuzzUnhashableMapKey(data []byte, item *dataItem) {
+	unhashable := hasUnhashableMapKey(item)
+
+	for _, dm := range []cbor.DecMode{dmDefault, dmDupMapKeyEnforcedAPF} {
+		// Decode to map[interface{}]interface{}
+		var m map[interface{}]interface{}
+		err := dm.NewDecoder(bytes.NewReader(data)).Decode(&m)
+		checkUnhashableMapKeyError(data, err, unhashable)
+
+		// Decode to interface{}
+		var v interface{}
+		err = dm.NewDecoder(bytes.NewReader(data)).Decode(&v)
+		checkUnhashableMapKeyError(data, err, unhashable)
+
+		if dm == dmDefault && item.major == majorTypeMap {
+			checkMapKeys(data, m, item)
+			vm, ok := v.(map[interface{}]interface{})
+			if !ok {
+				panic(fmt.Sprintf("decoded CBOR map 0x%x to %T", data, v))
+			}
+			checkMapKeys(data, vm, item)
+		}
+
+		if item.major != majorTypeMap {
+			continue
+		}
+
+		// Decode to structs, which skip CBOR map keys that can't match struct fields.
+		hasArrayOrMapKey := false
+		for i := 0; i < item.numPairs(); i++ {
+			if k := item.key(i); k.major == majorTypeArray || k.major == majorTypeMap {
+				hasArrayOrMapKey = true
+			}
+		}
+		for _, ctor := range []func() interface{}{
+			func() interface{} { return new(t1) },
+			func() interface{} { return new(t2) },
+			func() interface{} { return new(claims) },
+		} {
+			v1 := ctor()
+			err := dm.NewDecoder(bytes.NewReader(data)).Decode(v1)
+			if hasArrayOrMapKey && err == nil {
+				panic(fmt.Sprintf("decoded CBOR map 0x%x with array or map key to %T without error", data, v1))
+			}
+
+			if dm != dmDefault || !hasArrayOrMapKey {
+				continue
+			}
+
+			// Decode the same map without array and map keys, and compare results.
+			filtered := filterMapPairs(item, func(k, _ *dataItem) bool {
+				return k.major != majorTypeArray && k.major != majorTypeMap
+			})
+			v2 := ctor()
+			dm.NewDecoder(bytes.NewReader(filtered)).Decode(v2)
+			if !DeepEqual(v1, v2) {
+				panic(fmt.Sprintf("decoding CBOR map 0x%x to %T isn't the same without array and map keys: v1 %v, v2 %v", data, v1, v1, v2))
+			}
+		}
+	}
+}
+
+func checkUnhashableMapKeyError(data []byte, err error, unhashable bool) {
+	if unhashable && err == nil {
+		panic(fmt.Sprintf("decoded CBOR map 0x%x with unhashable key without error", data))
+	}
+	if _, ok := err.(*cbor.InvalidMapKeyTypeError); ok && !unhashable {
+		panic(fmt.Sprintf("decoding CBOR 0x%x without unhashable map key returned %v", data, err))
+	}
+}
+
+// checkMapKeys checks that decoded map m has the same keys as map item.
+// Key-value pairs are skipped if key is unhashable, or if key or value can't be decoded.
+func checkMapKeys(data []byte, m map[interface{}]interface{}, item *dataItem) {
+	want := make(map[interface{}]struct{})
+	for i := 0; i < item.numPairs(); i++ {
+		k, v := item.key(i), item.value(i)
+		if unhashableMapKey(k) {
+			continue
+		}
+		var gk, gv interface{}
+		if cbor.Unmarshal(k.raw, &gk) != nil || cbor.Unmarshal(v.raw, &gv) != nil {
+			continue
+		}
+		want[byteStringMapKey(gk)] = struct{}{}
+	}
+	if len(want) != len(m) {
+		panic(fmt.Sprintf("decoded CBOR map 0x%x has %d keys, want %d", data, len(m), len(want)))
+	}
+	for k := range want {
+		if k != k {
+			// NaN can't be found in map.
+			continue
+		}
+		if _, ok := m[k]; !ok {
+			panic(fmt.Sprintf("decoded CBOR map 0x%x doesn't have key %v (%T)", data, k, k))
+		}
+	}
+}
+
+// hasUnhashableMapKey returns true if any map inside item has unhashable key.
+func hasUnhashableMapKey(item *dataItem) bool {
+	found := false
+	item.walk(func(it *dataItem) {
+		if it.major != majorTypeMap {
+			return
+		}
+		for i := 0; i < it.numPairs(); i++ {
+			if unhashableMapKey(it.key(i)) {
+				found = true
+			}
+		}
+	})
+	return found
+}
+
+// unhashableMapKey returns true if map key item is decoded to Go value that can't be Go map key,
+// when decoding to empty interface with default options.  CBOR byte strings are decoded
+// to cbor.ByteString, which can be Go map key.
+func unhashableMapKey(item *dataItem) bool {
+	switch item.major {
+	case majorTypeNegativeInt:
+		// Decoded to big.Int if value overflows int64.
+		return item.val > math.MaxInt64
+	case majorTypeArray, majorTypeMap:
+		return true
+	case majorTypeTag:
+		switch item.val {
+		case 0, 1:
+			return false
+		case 2, 3:
+			// Decoded to big.Int.
+			return true
+		}
+		return unhashableMapKey(item.tagContent())
+	}
+	return false
+}
+
+// byteStringMapKey converts []byte in decoded map key to cbor.ByteString, the same as the library.
+func byteStringMapKey(k interface{}) interface{} {
+	switch k := k.(type) {
+	case []byte:
+		return cbor.ByteString(k)
+	case cbor.Tag:
+		return cbor.Tag{Number: k.Number, Content: byteStringMapKey(k.Content)}
+	}
+	return k
+}
+
+// filterMapPairs returns definite length CBOR map with key-value pairs of map item that satisfy keep.
+func filterMapPairs(item *dataItem, keep func(k, v *dataItem) bool) []byte {
+	var pairs []byte
+	n := 0
+	for i := 0; i < item.numPairs(); i++ {
+		if keep(item.key(i), item.value(i)) {
+			pairs = append(pairs, item.key(i).raw...)
+			pairs = append(pairs, item.value(i).raw...)
+			n++
+		}
+	}
+	return append(appendHead(nil, majorTypeMap, uint64(n)), pairs...)
+}
@@ -0,0 +1,346 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"math/big"
+	"math/rand"
+	"reflect"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxFillDepth is the max nested level of values set by fillValue.
+const maxFillDepth = 3
+
+// nonZeroStride is the stride of constructors whose types are checked by
+// fuzzDecodeToNonZeroValue for the same data.
+const nonZeroStride = 4
+
+// fuzzDecodeToNonZeroValue decodes data to a non-zero value of the same type as v,
+// which is data decoded to zero value, and compares result with decodeModel.  Scalar values
+// are replaced unless item is null or undefined, so they are skipped otherwise.  Only every
+// nonZeroStride-th constructor, starting from one picked by data, is checked, so that
+// different data check different types.
+func fuzzDecodeToNonZeroValue(data []byte, item *dataItem, ctorIndex int, ctor func() interface{}, v interface{}) {
+	if v == nil {
+		return
+	}
+	if isScalarKind(reflect.TypeOf(v).Elem().Kind()) && !isNull(skipTags(item)) {
+		return
+	}
+
+	h := fnv.New64a()
+	h.Write(data)
+	seed := int64(h.Sum64())
+	if uint64(seed)%nonZeroStride != uint64(ctorIndex)%nonZeroStride {
+		return
+	}
+
+	// Decode to non-zero value.
+	v1 := ctor()
+	fillValue(reflect.ValueOf(v1).Elem(), newRand(seed), 0)
+	dec := cbor.NewDecoder(bytes.NewReader(data))
+	if err := dec.Decode(v1); err != nil {
+		panic(fmt.Sprintf("decoding 0x%x to non-zero %T returned %v", data, v1, err))
+	}
+
+	// Update the same non-zero value with documented rules.
+	v2 := ctor()
+	fillValue(reflect.ValueOf(v2).Elem(), newRand(seed), 0)
+	if err := decodeModel(reflect.ValueOf(v2).Elem(), reflect.ValueOf(v).Elem(), item); err != nil {
+		panic(err)
+	}
+
+	if !DeepEqual(v1, v2) {
+		rv1, rv2 := reflect.ValueOf(v1).Elem(), reflect.ValueOf(v2).Elem()
+		panic(fmt.Sprintf("decoding 0x%x to non-zero %s returned %v, want %v", data, rv1.Type(), rv1.Interface(), rv2.Interface()))
+	}
+}
+
+// decodeModel updates v, a non-zero value, the same way as decoding data item to v,
+// following the library's documented rules for decoding to existing values.
+// fresh is data item decoded to zero value, and it is used for values that are replaced.
+func decodeModel(v, fresh reflect.Value, item *dataItem) error {
+	t := v.Type()
+
+	// Pointer is set to nil for null, otherwise existing value it points to is reused.
+	if t.Kind() == reflect.Ptr {
+		if isNull(item) {
+			v.Set(reflect.Zero(t))
+		} else if v.IsNil() {
+			v.Set(fresh)
+		} else {
+			return decodeModel(v.Elem(), fresh.Elem(), item)
+		}
+		return nil
+	}
+
+	// Self-described CBOR tag numbers are removed before decoding to non-pointer value,
+	// so 55799(null) into pointer decodes null to the value it points to.
+	for item.major == majorTypeTag && item.val == 55799 {
+		item = item.tagContent()
+	}
+
+	// Unmarshaler decides how to decode to existing value.
+	if reflect.PtrTo(t).Implements(typeUnmarshaler) {
+		return v.Addr().Interface().(cbor.Unmarshaler).UnmarshalCBOR(item.raw)
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		// Empty interface value is replaced, except for null.
+		if !isNull(item) {
+			v.Set(fresh)
+		}
+		return nil
+	}
+
+	if t == typeTime || t == typeTag {
+		if !isNull(item) {
+			v.Set(fresh)
+		}
+		return nil
+	}
+
+	// Tag numbers are skipped, except for bignum which replaces existing value.
+	for item.major == majorTypeTag {
+		if item.val == 2 || item.val == 3 {
+			v.Set(fresh)
+			return nil
+		}
+		item = item.tagContent()
+	}
+
+	// Null and undefined set slice and map to nil, and have no effect on other types.
+	if isNull(item) {
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Map {
+			v.Set(reflect.Zero(t))
+		}
+		return nil
+	}
+
+	// big.Int is replaced, except that CBOR map is decoded to it as struct without exported fields.
+	if t == typeBigInt && item.major != majorTypeMap {
+		v.Set(fresh)
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Slice:
+		// Existing slice is reused if its capacity is enough for non-empty CBOR array.
+		n := len(item.items)
+		if item.major != majorTypeArray || v.IsNil() || v.Cap() < n || n == 0 {
+			v.Set(fresh)
+			return nil
+		}
+		v.SetLen(n)
+		for i := 0; i < n; i++ {
+			if err := decodeModel(v.Index(i), fresh.Index(i), item.items[i]); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Array:
+		// Array elements are decoded to existing elements, and extra elements are set to zero.
+		if item.major != majorTypeArray {
+			v.Set(fresh)
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if i >= len(item.items) {
+				v.Index(i).Set(reflect.Zero(t.Elem()))
+				continue
+			}
+			if err := decodeModel(v.Index(i), fresh.Index(i), item.items[i]); err != nil {
+				return err
+			}
+		}
+
+	case reflect.Map:
+		// Existing map keeps existing entries, and decoded entries replace entries with the same key.
+		if v.IsNil() {
+			v.Set(fresh)
+			return nil
+		}
+		for iter := fresh.MapRange(); iter.Next(); {
+			v.SetMapIndex(iter.Key(), iter.Value())
+		}
+
+	case reflect.Struct:
+		// Matched struct fields are decoded to existing field values, and other fields are unchanged.
+		flds, toArray := structFields(t)
+		if toArray {
+			for i, fld := range flds {
+				if err := decodeModel(v.Field(fld.idx), fresh.Field(fld.idx), item.items[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for j, i := range matchStructFields(flds, item) {
+			if i < 0 {
+				continue
+			}
+			if err := decodeModel(v.Field(flds[i].idx), fresh.Field(flds[i].idx), item.value(j)); err != nil {
+				return err
+			}
+		}
+
+	default:
+		v.Set(fresh)
+	}
+	return nil
+}
+
+// fillValue sets v to random value generated by r.  Values nested deeper than maxFillDepth
+// splitMix64 is rand.Source64 that is cheap to seed, because a new source is seeded for each
+// value filled by fillValue.
+type splitMix64 uint64
+
+func newRand(seed int64) *rand.Rand {
+	s := splitMix64(seed)
+	return rand.New(&s)
+}
+
+func (s *splitMix64) Seed(seed int64) {
+	*s = splitMix64(seed)
+}
+
+func (s *splitMix64) Uint64() uint64 {
+	*s += 0x9e3779b97f4a7c15
+	z := uint64(*s)
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	return z ^ z>>31
+}
+
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
+
+// isScalarKind returns true if Go value of kind k is replaced by decoding.
+func isScalarKind(k reflect.Kind) bool {
+	return k == reflect.Bool || k == reflect.String || isNumberKind(k)
+}
+
+// are left as zero values, so recursive types are finite.
+func fillValue(v reflect.Value, r *rand.Rand, depth int) {
+	if depth > maxFillDepth || !v.CanSet() {
+		return
+	}
+
+	t := v.Type()
+	switch t {
+	case typeTime:
+		v.Set(reflect.ValueOf(time.Unix(r.Int63n(1<<32), 0)))
+		return
+	case typeBigInt:
+		bi := new(big.Int).Lsh(big.NewInt(r.Int63()), uint(r.Intn(128)))
+		if r.Intn(2) == 0 {
+			bi.Neg(bi)
+		}
+		v.Set(reflect.ValueOf(*bi))
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		v.SetBool(r.Intn(2) == 0)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(r.Uint64()) >> uint(64-t.Bits()))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(r.Uint64() >> uint(64-t.Bits()))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(float32(r.NormFloat64() * 1000)))
+	case reflect.String:
+		v.SetString(randomString(r))
+	case reflect.Interface:
+		if s := randomScalar(r); s != nil && t.NumMethod() == 0 {
+			v.Set(reflect.ValueOf(s))
+		}
+	case reflect.Ptr:
+		if r.Intn(4) == 0 {
+			return
+		}
+		p := reflect.New(t.Elem())
+		fillValue(p.Elem(), r, depth+1)
+		v.Set(p)
+	case reflect.Slice:
+		if r.Intn(4) == 0 {
+			return
+		}
+		n := r.Intn(4)
+		s := reflect.MakeSlice(t, n, n)
+		for i := 0; i < n; i++ {
+			fillValue(s.Index(i), r, depth+1)
+		}
+		v.Set(s)
+	case reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fillValue(v.Index(i), r, depth+1)
+		}
+	case reflect.Map:
+		if r.Intn(4) == 0 {
+			return
+		}
+		m := reflect.MakeMap(t)
+		for i, n := 0, r.Intn(4); i < n; i++ {
+			k, e := reflect.New(t.Key()).Elem(), reflect.New(t.Elem()).Elem()
+			fillValue(k, r, depth+1)
+			fillValue(e, r, depth+1)
+			m.SetMapIndex(k, e)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			fillValue(v.Field(i), r, depth+1)
+		}
+	}
+}
+
+// randomScalar returns random Go value that can be Go map key.
+func randomScalar(r *rand.Rand) interface{} {
+	switch r.Intn(6) {
+	case 0:
+		return r.Uint64()
+	case 1:
+		return -r.Int63()
+	case 2:
+		return r.NormFloat64()
+	case 3:
+		return randomString(r)
+	case 4:
+		return r.Intn(2) == 0
+	}
+	return nil
+}
+
+func randomString(r *rand.Rand) string {
+	b := make([]byte, r.Intn(8))
+	for i := range b {
+		b[i] = byte('a' + r.Intn(26))
+	}
+	return string(b)
+}
+
+// isNull returns true if item is CBOR null or undefined.
+func isNull(item *dataItem) bool {
+	return item.major == majorTypePrimitives && (item.ai == 22 || item.ai == 23)
+}
+
+// hasTagNumber returns true if item or any item nested inside it is tag number num.
+func hasTagNumber(item *dataItem, num uint64) bool {
+	found := false
+	item.walk(func(it *dataItem) {
+		if it.major == majorTypeTag && it.val == num {
+			found = true
+		}
+	})
+	return found
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// fuzzNestedLevels decodes data to recursive types with default and tight MaxNestedLevels,
+// and checks that MaxNestedLevelError is returned exactly when data is nested too deep.
+func fuzzNestedLevels(data []byte, item *dataItem) {
+	if len(item.raw) > 131072 {
+		// Data can exceed default MaxArrayElements or MaxMapPairs.
+		return
+	}
+	level := item.nestedLevel()
+
+	for _, ctor := range []func() interface{}{
+		func() interface{} { return new(interface{}) },
+		func() interface{} { return new(treeNode) },
+		func() interface{} { return new(treeArrayNode) },
+		func() interface{} { return new(listNode) },
+		func() interface{} { return new(nestedStruct) },
+	} {
+		v1 := ctor()
+		err1 := dmDefault.NewDecoder(bytes.NewReader(data)).Decode(v1)
+		checkMaxNestedLevelError(data, err1, level, 32)
+
+		v2 := ctor()
+		err2 := dmMaxNestedLevels4.NewDecoder(bytes.NewReader(data)).Decode(v2)
+		checkMaxNestedLevelError(data, err2, level, 4)
+
+		if level > 4 {
+			continue
+		}
+		if (err1 == nil) != (err2 == nil) {
+			panic(fmt.Sprintf("decoding 0x%x to %T with MaxNestedLevels 32 and 4 returned %v and %v", data, v1, err1, err2))
+		}
+		if err1 == nil && !DeepEqual(v1, v2) {
+			rv1, rv2 := reflect.ValueOf(v1).Elem(), reflect.ValueOf(v2).Elem()
+			panic(fmt.Sprintf("decoding 0x%x with MaxNestedLevels 32 and 4 returned %v (%s) and %v (%s)", data, rv1.Interface(), rv1.Type(), rv2.Interface(), rv2.Type()))
+		}
+	}
+}
+
+func checkMaxNestedLevelError(data []byte, err error, level int, maxNestedLevels int) {
+	_, ok := err.(*cbor.MaxNestedLevelError)
+	if level > maxNestedLevels && !ok {
+		panic(fmt.Sprintf("decoding 0x%x with nested level %d and MaxNestedLevels %d returned %v, want MaxNestedLevelError", data, level, maxNestedLevels, err))
+	}
+	if level <= maxNestedLevels && ok {
+		panic(fmt.Sprintf("decoding 0x%x with nested level %d and MaxNestedLevels %d returned %v", data, level, maxNestedLevels, err))
+	}
+}
@@ -0,0 +1,223 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxFuzzTimeTags is the max number of tag 0 and 1 items in data checked by fuzzTimeTag.
+const maxFuzzTimeTags = 8
+
+var (
+	errRFC3339Syntax = errors.New("rfc3339: invalid RFC 3339 date-time")
+	errRFC3339Range  = errors.New("rfc3339: RFC 3339 date-time field out of range")
+	errEpochRange    = errors.New("rfc3339: epoch time overflows int64 seconds")
+	errTimeContent   = errors.New("rfc3339: invalid content for time")
+)
+
+// fuzzTimeTag decodes tag 0 and 1 items in item to time.Time, and compares acceptance and
+// result with timeTagModel.
+func fuzzTimeTag(item *dataItem) {
+	n := 0
+	item.walk(func(it *dataItem) {
+		if n >= maxFuzzTimeTags || it.major != majorTypeTag || (it.val != 0 && it.val != 1) {
+			return
+		}
+		n++
+
+		want, wantErr, ok := timeTagModel(it)
+		if !ok {
+			return
+		}
+		var got time.Time
+		err := cbor.Unmarshal(it.raw, &got)
+		if wantErr != nil {
+			if err == nil {
+				panic(fmt.Sprintf("decoding 0x%x to time.Time returned %v, want error %v", it.raw, got, wantErr))
+			}
+			return
+		}
+		if err != nil {
+			panic(fmt.Sprintf("decoding 0x%x to time.Time returned %v, want %v", it.raw, err, want))
+		}
+		if !got.Equal(want) {
+			panic(fmt.Sprintf("decoding 0x%x to time.Time returned %v, want %v", it.raw, got, want))
+		}
+	})
+}
+
+// timeTagModel returns time of tag 0 or 1 item, or error if item can't be decoded to time.Time.
+// It returns false if result isn't specified.
+func timeTagModel(item *dataItem) (time.Time, error, bool) {
+	content := item.tagContent()
+	if item.val == 0 {
+		// Tag 0 content must be RFC 3339 date-time text string (RFC 8949 section 3.4.1).
+		if content.major != majorTypeTextString {
+			return time.Time{}, errTimeContent, true
+		}
+		if !validTextString(content) {
+			return time.Time{}, errRFC3339Syntax, true
+		}
+		s := string(content.content)
+		t, err := parseRFC3339(s)
+		return t, err, !goTimeParseDeviation(s)
+	}
+
+	// Tag 1 content must be integer or float (RFC 8949 section 3.4.2).
+	switch content.major {
+	case majorTypePositiveInt, majorTypeNegativeInt:
+		if content.val > math.MaxInt64 {
+			return time.Time{}, errEpochRange, true
+		}
+		secs := int64(content.val)
+		if content.major == majorTypeNegativeInt {
+			secs = -1 ^ secs
+		}
+		return time.Unix(secs, 0), nil, true
+
+	case majorTypePrimitives:
+		f, isFloat := content.float()
+		if !isFloat {
+			break
+		}
+		switch {
+		case math.IsNaN(f) || math.IsInf(f, 0):
+			// Non-finite epoch time is decoded to zero time.
+			return time.Time{}, nil, true
+		case f >= 1<<63 || f <= -1<<63:
+			// Float to int64 conversion of out of range value is implementation-specific in Go.
+			return time.Time{}, nil, false
+		}
+		// Fraction of second is truncated toward zero to nanoseconds.
+		secs := math.Trunc(f)
+		return time.Unix(int64(secs), int64((f-secs)*1e9)), nil, true
+	}
+	return time.Time{}, errTimeContent, true
+}
+
+// parseRFC3339 parses date-time defined in RFC 3339 section 5.6:
+//
+//	date-time = full-date "T" full-time
+//	full-date = date-fullyear "-" date-month "-" date-mday
+//	full-time = partial-time time-offset
+//	partial-time = time-hour ":" time-minute ":" time-second [time-secfrac]
+//	time-offset = "Z" / time-numoffset
+//
+// "T" and "Z" are case-insensitive.  Leap second 60 is accepted if it's the last second of
+// the UTC day.  Fraction of second is truncated to nanoseconds.
+func parseRFC3339(s string) (time.Time, error) {
+	digits := func(off, n int) (int, bool) {
+		if off+n > len(s) {
+			return 0, false
+		}
+		v := 0
+		for i := off; i < off+n; i++ {
+			if s[i] < '0' || s[i] > '9' {
+				return 0, false
+			}
+			v = v*10 + int(s[i]-'0')
+		}
+		return v, true
+	}
+	sep := func(off int, c byte) bool {
+		return off < len(s) && (s[off] == c || (c >= 'A' && c <= 'Z' && s[off] == c+'a'-'A'))
+	}
+
+	year, ok1 := digits(0, 4)
+	month, ok2 := digits(5, 2)
+	day, ok3 := digits(8, 2)
+	hour, ok4 := digits(11, 2)
+	min, ok5 := digits(14, 2)
+	sec, ok6 := digits(17, 2)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 ||
+		!sep(4, '-') || !sep(7, '-') || !sep(10, 'T') || !sep(13, ':') || !sep(16, ':') {
+		return time.Time{}, errRFC3339Syntax
+	}
+
+	off := 19
+	nsec := 0
+	if off < len(s) && s[off] == '.' {
+		off++
+		start := off
+		for off < len(s) && s[off] >= '0' && s[off] <= '9' {
+			if off-start < 9 {
+				nsec = nsec*10 + int(s[off]-'0')
+			}
+			off++
+		}
+		if off == start {
+			return time.Time{}, errRFC3339Syntax
+		}
+		for i := off - start; i < 9; i++ {
+			nsec *= 10
+		}
+	}
+
+	offset := 0
+	switch {
+	case sep(off, 'Z'):
+		off++
+	case off < len(s) && (s[off] == '+' || s[off] == '-'):
+		oh, ok1 := digits(off+1, 2)
+		om, ok2 := digits(off+4, 2)
+		if !ok1 || !ok2 || !sep(off+3, ':') {
+			return time.Time{}, errRFC3339Syntax
+		}
+		if oh > 23 || om > 59 {
+			return time.Time{}, errRFC3339Range
+		}
+		offset = (oh*60 + om) * 60
+		if s[off] == '-' {
+			offset = -offset
+		}
+		off += 6
+	default:
+		return time.Time{}, errRFC3339Syntax
+	}
+	if off != len(s) {
+		return time.Time{}, errRFC3339Syntax
+	}
+
+	// Day must be valid for month and year (RFC 3339 section 5.7).
+	mdays := [...]int{31, 28, 31, 30, 31, 30, 31, 31, 30, 31, 30, 31}
+	if month >= 1 && month <= 12 {
+		if month == 2 && year%4 == 0 && (year%100 != 0 || year%400 == 0) {
+			mdays[1] = 29
+		}
+	}
+	if month < 1 || month > 12 || day < 1 || day > mdays[month-1] || hour > 23 || min > 59 || sec > 60 {
+		return time.Time{}, errRFC3339Range
+	}
+
+	t := time.Date(year, time.Month(month), day, hour, min, sec, nsec, time.UTC).Add(-time.Duration(offset) * time.Second)
+	if sec == 60 {
+		// Leap second can only be the last second of UTC day.
+		if u := t.Add(-time.Second); u.Hour() != 23 || u.Minute() != 59 || u.Second() != 59 {
+			return time.Time{}, errRFC3339Range
+		}
+	}
+	return t, nil
+}
+
+// goTimeParseDeviation returns true if s uses RFC 3339 syntax where Go's time.Parse, used by
+// the library, deliberately differs from RFC 3339: lowercase "t" and "z" and leap second 60
+// are rejected, while "," before fraction of second and offsets beyond 23:59 are accepted.
+func goTimeParseDeviation(s string) bool {
+	if len(s) < 20 {
+		return false
+	}
+	if s[10] == 't' || s[len(s)-1] == 'z' || s[17:19] == "60" || s[19] == ',' {
+		return true
+	}
+	if n := len(s); s[n-6] == '+' || s[n-6] == '-' {
+		return s[n-5:n-3] > "23" || s[n-2:] > "59"
+	}
+	return false
+}
@@ -0,0 +1,440 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"math"
+	"math/big"
+	"mime"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxFuzzSemanticTags is the max number of tag items in data checked by fuzzSemanticTag.
+const maxFuzzSemanticTags = 8
+
+// Go types registered with standard tag numbers (RFC 8949 section 3.4).  The library decodes
+// tag content to registered types without semantic validation: decimal fractions and
+// bigfloats to structs, expected conversions to byte slices, and other tags to strings.
+// Content validity is checked independently by semanticContentValid.
+type (
+	// decimalFraction is m*(10**e) (RFC 8949 section 3.4.4).
+	decimalFraction struct {
+		_        struct{} `cbor:",toarray"`
+		Exponent int64
+		Mantissa big.Int
+	}
+	// bigfloat is m*(2**e) (RFC 8949 section 3.4.4).
+	bigfloat decimalFraction
+
+	// expectedBase64URL, expectedBase64, and expectedBase16 are byte strings expected to be
+	// converted to base64url, base64, or base16 (RFC 8949 section 3.4.5.2).
+	expectedBase64URL []byte
+	expectedBase64    []byte
+	expectedBase16    []byte
+
+	// uri, base64URLText, base64Text, regexpText, and mimeMessage are text strings of URI,
+	// base64url, base64, regular expression, and MIME message (RFC 8949 section 3.4.5.3).
+	uri           string
+	base64URLText string
+	base64Text    string
+	regexpText    string
+	mimeMessage   string
+)
+
+// semanticTags are Go types registered with standard tag numbers.
+var semanticTags = []struct {
+	num uint64
+	t   reflect.Type
+}{
+	{4, reflect.TypeOf(decimalFraction{})},
+	{5, reflect.TypeOf(bigfloat{})},
+	{21, reflect.TypeOf(expectedBase64URL(nil))},
+	{22, reflect.TypeOf(expectedBase64(nil))},
+	{23, reflect.TypeOf(expectedBase16(nil))},
+	{32, reflect.TypeOf(uri(""))},
+	{33, reflect.TypeOf(base64URLText(""))},
+	{34, reflect.TypeOf(base64Text(""))},
+	{35, reflect.TypeOf(regexpText(""))},
+	{36, reflect.TypeOf(mimeMessage(""))},
+}
+
+var dmSemanticTags, emSemanticTags = func() (cbor.DecMode, cbor.EncMode) {
+	tags := cbor.NewTagSet()
+	for _, st := range semanticTags {
+		if err := tags.Add(cbor.TagOptions{EncTag: cbor.EncTagRequired, DecTag: cbor.DecTagRequired}, st.t, st.num); err != nil {
+			panic(err)
+		}
+	}
+	dm, err := cbor.DecOptions{}.DecModeWithTags(tags)
+	if err != nil {
+		panic(err)
+	}
+	em, err := cbor.EncOptions{}.EncModeWithTags(tags)
+	if err != nil {
+		panic(err)
+	}
+	return dm, em
+}()
+
+// fuzzSemanticTag decodes standard tag items in item without registered types, and with
+// registered types.  Decoded values are compared with semanticTagModel, and each tag item is
+// rejected by types registered with other tag numbers.  Self-described CBOR tag must not
+// change decoded values.
+func fuzzSemanticTag(item *dataItem) {
+	n := 0
+	item.walk(func(it *dataItem) {
+		if n >= maxFuzzSemanticTags || it.major != majorTypeTag {
+			return
+		}
+		if it.val == 55799 {
+			n++
+			fuzzSelfDescribedTag(it)
+			return
+		}
+		for i, st := range semanticTags {
+			if st.num == it.val {
+				n++
+				fuzzRegisteredSemanticTag(it, i)
+				return
+			}
+		}
+	})
+}
+
+// fuzzRegisteredSemanticTag checks tag item with tag number of semanticTags[index].
+func fuzzRegisteredSemanticTag(item *dataItem, index int) {
+	st := semanticTags[index]
+	content := item.tagContent()
+	for content.major == majorTypeTag && content.val == 55799 {
+		content = content.tagContent()
+	}
+
+	// Tag number isn't registered with default decoding options, so tag is decoded to
+	// cbor.Tag and cbor.RawTag with its number and content.
+	var iv interface{}
+	if err := dmDefault.Unmarshal(item.raw, &iv); err == nil {
+		var want interface{}
+		if err := dmDefault.Unmarshal(content.raw, &want); err != nil {
+			panic(fmt.Sprintf("decoding tag %d 0x%x returned no error, decoding its content 0x%x returned %v", st.num, item.raw, content.raw, err))
+		}
+		// Nested tag 55799 is kept in tag content.
+		selfDescribed := item.tagContent().major == majorTypeTag && item.tagContent().val == 55799
+		if tag, ok := iv.(cbor.Tag); !ok || tag.Number != st.num || (!selfDescribed && !roundTripEqual(&tag.Content, &want)) {
+			panic(fmt.Sprintf("decoding tag %d 0x%x to interface{} returned %v (%T), want cbor.Tag with content %v", st.num, item.raw, iv, iv, want))
+		}
+
+		var rt cbor.RawTag
+		if err := dmDefault.Unmarshal(item.raw, &rt); err != nil {
+			panic(fmt.Sprintf("decoding tag %d 0x%x to cbor.RawTag returned %v", st.num, item.raw, err))
+		}
+		if rt.Number != st.num || !bytes.Equal(rt.Content, item.tagContent().raw) {
+			panic(fmt.Sprintf("decoding tag %d 0x%x to cbor.RawTag returned number %d and content 0x%x, want content 0x%x", st.num, item.raw, rt.Number, rt.Content, item.tagContent().raw))
+		}
+	}
+
+	// Each tag item is rejected by types registered with other tag numbers.
+	for i, other := range semanticTags {
+		if i == index {
+			continue
+		}
+		if err := dmSemanticTags.Unmarshal(item.raw, reflect.New(other.t).Interface()); err == nil {
+			panic(fmt.Sprintf("decoding tag %d 0x%x to %s registered with tag %d returned no error", st.num, item.raw, other.t, other.num))
+		}
+	}
+
+	want, valid, ok := semanticTagModel(st.num, st.t, item.tagContent())
+	if !ok {
+		return
+	}
+	v := reflect.New(st.t)
+	err := dmSemanticTags.Unmarshal(item.raw, v.Interface())
+	if !valid {
+		if err == nil {
+			panic(fmt.Sprintf("decoding tag %d 0x%x with invalid content to %s returned %+v", st.num, item.raw, st.t, v.Elem()))
+		}
+		return
+	}
+	if err != nil {
+		panic(fmt.Sprintf("decoding tag %d 0x%x to %s returned %v", st.num, item.raw, st.t, err))
+	}
+	if !roundTripEqual(v.Interface(), want.Interface()) {
+		panic(fmt.Sprintf("decoding tag %d 0x%x to %s returned %+v, want %+v", st.num, item.raw, st.t, v.Elem(), want.Elem()))
+	}
+
+	// Registered type is decoded to interface{}, unless tag content is also a tag.
+	if item.tagContent().major != majorTypeTag {
+		var iv interface{}
+		if err := dmSemanticTags.Unmarshal(item.raw, &iv); err != nil {
+			panic(fmt.Sprintf("decoding tag %d 0x%x to interface{} with registered %s returned %v", st.num, item.raw, st.t, err))
+		}
+		if reflect.TypeOf(iv) != st.t || !roundTripEqual(iv, want.Elem().Interface()) {
+			panic(fmt.Sprintf("decoding tag %d 0x%x to interface{} with registered %s returned %v (%T)", st.num, item.raw, st.t, iv, iv))
+		}
+	}
+
+	// Required tag number can't be omitted.
+	if content.major != majorTypeTag {
+		if err := dmSemanticTags.Unmarshal(content.raw, reflect.New(st.t).Interface()); err == nil {
+			panic(fmt.Sprintf("decoding content 0x%x of tag %d without tag number to %s returned no error", content.raw, st.num, st.t))
+		}
+	}
+
+	b, err := emSemanticTags.Marshal(v.Interface())
+	if err != nil {
+		panic(fmt.Sprintf("encoding %s returned %v", st.t, err))
+	}
+	if v.Elem().Kind() == reflect.Slice && v.Elem().IsNil() {
+		// Nil slice is encoded as null without tag number, so it isn't decoded again with
+		// required tag number.
+		if !bytes.Equal(b, []byte{0xf6}) {
+			panic(fmt.Sprintf("encoding nil %s produced 0x%x, want 0xf6", st.t, b))
+		}
+		return
+	}
+	encoded, _, err := parseDataItem(b)
+	if err != nil || encoded.major != majorTypeTag || encoded.val != st.num {
+		panic(fmt.Sprintf("encoding %s produced 0x%x without tag number %d", st.t, b, st.num))
+	}
+
+	// Content invalid for the tag is accepted by design, so string and byte string content
+	// must round trip unchanged, valid or not.  Valid content must stay valid.
+	if (content.major == majorTypeTextString || content.major == majorTypeByteString) &&
+		(encoded.tagContent().major != content.major || !bytes.Equal(encoded.tagContent().content, content.content)) {
+		panic(fmt.Sprintf("tag %d 0x%x (valid %t) round tripped through %s to 0x%x", st.num, item.raw, semanticContentValid(st.num, content), st.t, b))
+	}
+	if semanticContentValid(st.num, content) && !semanticContentValid(st.num, encoded.tagContent()) {
+		panic(fmt.Sprintf("tag %d 0x%x with valid content round tripped through %s to invalid 0x%x", st.num, item.raw, st.t, b))
+	}
+	v2 := reflect.New(st.t)
+	if err := dmSemanticTags.Unmarshal(b, v2.Interface()); err != nil {
+		panic(fmt.Sprintf("decoding 0x%x to %s returned %v", b, st.t, err))
+	}
+	if !roundTripEqual(v.Interface(), v2.Interface()) {
+		panic(fmt.Sprintf("not equal: v1 %+v, v2 %+v (%s)", v.Elem(), v2.Elem(), st.t))
+	}
+}
+
+// semanticContentValid returns true if content item is valid for standard tag number num
+// (RFC 8949 section 3.4).
+func semanticContentValid(num uint64, content *dataItem) bool {
+	switch num {
+	case 4, 5:
+		// Exponent is integer, and mantissa is integer or bignum.
+		if content.major != majorTypeArray || len(content.items) != 2 {
+			return false
+		}
+		e, m := content.items[0], content.items[1]
+		if e.major != majorTypePositiveInt && e.major != majorTypeNegativeInt {
+			return false
+		}
+		if m.major == majorTypeTag && (m.val == 2 || m.val == 3) {
+			return m.tagContent().major == majorTypeByteString
+		}
+		return m.major == majorTypePositiveInt || m.major == majorTypeNegativeInt
+	case 21, 22, 23:
+		// Expected conversion applies to any content.
+		return true
+	}
+
+	if content.major != majorTypeTextString || !validTextString(content) {
+		return false
+	}
+	s := string(content.content)
+	switch num {
+	case 32:
+		return validURI(s)
+	case 33:
+		_, err := base64.RawURLEncoding.Strict().DecodeString(s)
+		return err == nil
+	case 34:
+		_, err := base64.StdEncoding.Strict().DecodeString(s)
+		return err == nil
+	case 35:
+		// Go regexp syntax stands in for PCRE and ECMA 262.
+		_, err := regexp.Compile(s)
+		return err == nil
+	case 36:
+		return validMIMEMessage(s)
+	}
+	return false
+}
+
+// validURI returns true if s is absolute URI.
+func validURI(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && u.IsAbs()
+}
+
+// validMIMEMessage returns true if s is message with headers and valid Content-Type if any.
+func validMIMEMessage(s string) bool {
+	msg, err := mail.ReadMessage(strings.NewReader(s))
+	if err != nil {
+		return false
+	}
+	if ct := msg.Header.Get("Content-Type"); ct != "" {
+		if _, _, err := mime.ParseMediaType(ct); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// semanticTagModel returns pointer to Go value of registered type t that tag content item
+// with tag number num is decoded to, and false for valid if content is invalid.  It returns
+// false for ok if result isn't specified.
+func semanticTagModel(num uint64, t reflect.Type, content *dataItem) (v reflect.Value, valid bool, ok bool) {
+	// Registered tag number must be the only tag number, so tag content can't be a tag.
+	if content.major == majorTypeTag {
+		return reflect.Value{}, false, true
+	}
+	v = reflect.New(t)
+	if isNull(content) {
+		// Decoding null and undefined is no-op.
+		return v, true, true
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if content.major != majorTypeArray || len(content.items) != 2 {
+			return reflect.Value{}, false, true
+		}
+		// Exponent is decoded to int64 like mantissa to big.Int, except that CBOR map
+		// isn't decoded to int64.
+		e, valid := bigIntMantissaModel(content.items[0])
+		if !valid || !e.IsInt64() || skipIgnoredTags(content.items[0]).major == majorTypeMap {
+			return reflect.Value{}, false, true
+		}
+		m, valid := bigIntMantissaModel(content.items[1])
+		if !valid {
+			return reflect.Value{}, false, true
+		}
+		df := decimalFraction{Exponent: e.Int64(), Mantissa: *m}
+		v.Elem().Set(reflect.ValueOf(df).Convert(t))
+		return v, true, true
+
+	case reflect.Slice:
+		switch content.major {
+		case majorTypeByteString:
+			v.Elem().SetBytes(append([]byte{}, content.content...))
+			return v, true, true
+		case majorTypeArray:
+			// CBOR array is decoded to byte slice element by element like to other Go
+			// slices, which isn't what this oracle checks.
+			return reflect.Value{}, false, false
+		}
+		return reflect.Value{}, false, true
+	}
+
+	if content.major != majorTypeTextString || !validTextString(content) {
+		return reflect.Value{}, false, true
+	}
+	v.Elem().SetString(string(content.content))
+	return v, true, true
+}
+
+// skipIgnoredTags returns item without leading tag numbers other than 0 to 3, which are
+// ignored when decoding to integer types.
+func skipIgnoredTags(item *dataItem) *dataItem {
+	for item.major == majorTypeTag && item.val > 3 {
+		item = item.tagContent()
+	}
+	return item
+}
+
+// bigIntMantissaModel returns mantissa that item is decoded to as big.Int, and false if
+// decoding fails.
+func bigIntMantissaModel(item *dataItem) (*big.Int, bool) {
+	item = skipIgnoredTags(item)
+	if isNull(item) {
+		return new(big.Int), true
+	}
+
+	switch item.major {
+	case majorTypePositiveInt, majorTypeNegativeInt:
+		return item.bigInt(), true
+	case majorTypeTag:
+		content := item.tagContent()
+		switch item.val {
+		case 1:
+			// Epoch time is decoded as its integer content, and floats are rejected.
+			if content.major == majorTypePositiveInt || content.major == majorTypeNegativeInt {
+				return content.bigInt(), true
+			}
+		case 2, 3:
+			if content.major == majorTypeByteString {
+				neg, mag := bignumModel(item)
+				m := new(big.Int).SetBytes(mag)
+				if neg {
+					m.Neg(m)
+				}
+				return m, true
+			}
+		}
+		return nil, false
+	case majorTypePrimitives:
+		// Simple values are decoded like unsigned integers, and false, true, and floats
+		// are rejected.
+		if item.ai > 24 {
+			return nil, false
+		}
+		m, valid := simpleValueModel(item.val, typeBigInt)
+		if !valid {
+			return nil, false
+		}
+		mi := m.Interface().(big.Int)
+		return &mi, true
+	case majorTypeMap:
+		// CBOR map is decoded to big.Int as struct without exported fields, and map keys
+		// must be valid for struct field matching.
+		for i := 0; i < item.numPairs(); i++ {
+			k := item.key(i)
+			switch k.major {
+			case majorTypeTextString:
+				if !validTextString(k) {
+					return nil, false
+				}
+			case majorTypePositiveInt:
+			case majorTypeNegativeInt:
+				if k.val > math.MaxInt64 {
+					return nil, false
+				}
+			default:
+				return nil, false
+			}
+		}
+		return new(big.Int), true
+	}
+	return nil, false
+}
+
+// fuzzSelfDescribedTag checks that tag 55799 item is decoded the same as its content to
+// interface{} and to each registered type.
+func fuzzSelfDescribedTag(item *dataItem) {
+	content := item.tagContent()
+	for _, dm := range []cbor.DecMode{dmDefault, dmSemanticTags} {
+		var v1, v2 interface{}
+		err1 := dm.Unmarshal(item.raw, &v1)
+		err2 := dm.Unmarshal(content.raw, &v2)
+		if (err1 == nil) != (err2 == nil) || (err1 == nil && !roundTripEqual(&v1, &v2)) {
+			panic(fmt.Sprintf("decoding tag 55799 0x%x returned %v (%v), decoding its content 0x%x returned %v (%v)", item.raw, v1, err1, content.raw, v2, err2))
+		}
+	}
+	for _, st := range semanticTags {
+		v1, v2 := reflect.New(st.t), reflect.New(st.t)
+		err1 := dmSemanticTags.Unmarshal(item.raw, v1.Interface())
+		err2 := dmSemanticTags.Unmarshal(content.raw, v2.Interface())
+		if (err1 == nil) != (err2 == nil) || (err1 == nil && !roundTripEqual(v1.Interface(), v2.Interface())) {
+			panic(fmt.Sprintf("decoding tag 55799 0x%x to %s returned %+v (%v), decoding its content 0x%x returned %+v (%v)", item.raw, st.t, v1.Elem(), err1, content.raw, v2.Elem(), err2))
+		}
+	}
+}
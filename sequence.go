@@ -0,0 +1,156 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// sequenceEncModes are encoding modes that decoded CBOR Sequence is encoded with.
+var sequenceEncModes = []struct {
+	name string
+	em   cbor.EncMode
+}{
+	{"Default", emDefault},
+	{"Preferred", emPreferred},
+	{"Canonical", emCanonical},
+	{"CTAP2 Canonical", emCTAP2TagsAllowed},
+	{"Core Deterministic", emCoreDeterministic},
+}
+
+// FuzzSequence decodes CBOR Sequence (RFC 8742) with Decoder until EOF, and compares decoded
+// items with items parsed by reference parser.  Decoded items are encoded as CBOR Sequence
+// with each encoding mode and decoded again.  Unmarshal must return ExtraneousDataError
+// exactly when the first item is followed by more data.
+func FuzzSequence(data []byte) int {
+	// Parse sequence with reference parser.  Malformed data after the last item is kept in tail.
+	var items []*dataItem
+	var tailErr error
+	for rest := data; len(rest) > 0; {
+		item, r, err := parseDataItem(rest)
+		if err != nil {
+			tailErr = err
+			break
+		}
+		items = append(items, item)
+		rest = r
+	}
+
+	fuzzSequenceUnmarshal(data, items, tailErr)
+
+	var values []interface{}
+	n := 0 // number of bytes in decoded items
+	dec := cbor.NewDecoder(bytes.NewReader(data))
+	for i := 0; ; i++ {
+		var v interface{}
+		err := dec.Decode(&v)
+		if err == io.EOF {
+			if i != len(items) || tailErr != nil {
+				panic(fmt.Sprintf("decoding CBOR Sequence 0x%x returned EOF after %d items, want %d items (tail error %v)", data, i, len(items), tailErr))
+			}
+			break
+		}
+		if i >= len(items) {
+			if tailErr == nil || err == nil {
+				panic(fmt.Sprintf("decoding CBOR Sequence 0x%x returned item %d (%v), want %d items", data, i, err, len(items)))
+			}
+			break
+		}
+
+		// Item is decoded the same way as if it were decoded alone.
+		var want interface{}
+		wantErr := cbor.Unmarshal(items[i].raw, &want)
+		if (err == nil) != (wantErr == nil) {
+			panic(fmt.Sprintf("decoding item 0x%x in CBOR Sequence 0x%x returned %v, Unmarshal returned %v", items[i].raw, data, err, wantErr))
+		}
+		if err == nil {
+			if !roundTripEqual(&v, &want) {
+				panic(fmt.Sprintf("decoding item 0x%x in CBOR Sequence 0x%x returned %v, Unmarshal returned %v", items[i].raw, data, v, want))
+			}
+			values = append(values, v)
+		}
+
+		n += len(items[i].raw)
+		if dec.NumBytesRead() != n {
+			panic(fmt.Sprintf("decoding %d items in CBOR Sequence 0x%x read %d bytes, want %d bytes", i+1, data, dec.NumBytesRead(), n))
+		}
+	}
+	if len(values) == 0 {
+		return 0
+	}
+
+	for _, mode := range sequenceEncModes {
+		var buf bytes.Buffer
+		enc := mode.em.NewEncoder(&buf)
+		for _, v := range values {
+			if err := enc.Encode(v); err != nil {
+				panic(fmt.Sprintf("encoding %v (%T) in CBOR Sequence with %s encoding mode returned %v", v, v, mode.name, err))
+			}
+		}
+		encoded := buf.Bytes()
+
+		dec := cbor.NewDecoder(bytes.NewReader(encoded))
+		for i := 0; ; i++ {
+			var v interface{}
+			err := dec.Decode(&v)
+			if err == io.EOF {
+				if i != len(values) {
+					panic(fmt.Sprintf("decoding CBOR Sequence 0x%x encoded with %s encoding mode returned %d items, want %d items", encoded, mode.name, i, len(values)))
+				}
+				break
+			}
+			if err != nil {
+				panic(fmt.Sprintf("decoding CBOR Sequence 0x%x encoded with %s encoding mode returned %v", encoded, mode.name, err))
+			}
+			if i >= len(values) {
+				panic(fmt.Sprintf("decoding CBOR Sequence 0x%x encoded with %s encoding mode returned more than %d items", encoded, mode.name, len(values)))
+			}
+			if !roundTripEqual(&values[i], &v) {
+				panic(fmt.Sprintf("not equal: v1 %v, v2 %v (item %d in CBOR Sequence encoded with %s encoding mode)", values[i], v, i, mode.name))
+			}
+		}
+	}
+	return 1
+}
+
+// fuzzSequenceUnmarshal checks that Unmarshal returns ExtraneousDataError with number of
+// extraneous bytes and their index exactly when the first item in data is followed by more
+// items or malformed data.
+func fuzzSequenceUnmarshal(data []byte, items []*dataItem, tailErr error) {
+	var v interface{}
+	err := cbor.Unmarshal(data, &v)
+	var e *cbor.ExtraneousDataError
+	if len(items) == 0 || len(items[0].raw) == len(data) {
+		if errors.As(err, &e) {
+			panic(fmt.Sprintf("decoding CBOR Sequence 0x%x with %d items (tail error %v) returned %v", data, len(items), tailErr, err))
+		}
+		return
+	}
+	_, rest, _ := parseDataItem(data)
+	if !errors.As(err, &e) {
+		panic(fmt.Sprintf("decoding CBOR Sequence 0x%x with %d items (tail error %v) returned %v, want ExtraneousDataError", data, len(items), tailErr, err))
+	}
+	numOfBytes, index := extraneousDataFields(e)
+	if numOfBytes != len(rest) || index != len(data)-len(rest) {
+		panic(fmt.Sprintf("decoding CBOR Sequence 0x%x returned ExtraneousDataError with %d bytes at index %d, want %d bytes at index %d", data, numOfBytes, index, len(rest), len(data)-len(rest)))
+	}
+
+	// UnmarshalFirst returns the same extraneous data as rest if the first item is decoded.
+	if r, err := cbor.UnmarshalFirst(data, &v); err == nil && len(r) != len(rest) {
+		panic(fmt.Sprintf("UnmarshalFirst CBOR Sequence 0x%x returned %d bytes of rest, want %d bytes", data, len(r), len(rest)))
+	}
+}
+
+// extraneousDataFields returns the number of extraneous bytes and their index documented by
+// ExtraneousDataError's fields, which are unexported and have no accessors.
+func extraneousDataFields(e *cbor.ExtraneousDataError) (numOfBytes int, index int) {
+	rv := reflect.ValueOf(e).Elem()
+	return int(rv.FieldByName("numOfBytes").Int()), int(rv.FieldByName("index").Int())
+}
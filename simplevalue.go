@@ -0,0 +1,162 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"math/big"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// simpleValueTypes are Go types that CBOR simple values are decoded to by fuzzSimpleValue.
+var simpleValueTypes = []reflect.Type{
+	reflect.TypeOf((*interface{})(nil)).Elem(),
+	reflect.TypeOf(false),
+	reflect.TypeOf(uint(0)),
+	reflect.TypeOf(uint8(0)),
+	reflect.TypeOf(uint64(0)),
+	reflect.TypeOf(int(0)),
+	reflect.TypeOf(int8(0)),
+	reflect.TypeOf(int64(0)),
+	reflect.TypeOf(float32(0)),
+	reflect.TypeOf(float64(0)),
+	reflect.TypeOf(""),
+	reflect.TypeOf([]byte(nil)),
+	reflect.TypeOf([]int(nil)),
+	reflect.TypeOf(map[string]int(nil)),
+	typeBigInt,
+	typeSimpleValue,
+}
+
+// fuzzSimpleValue decodes CBOR simple value (false, true, null, undefined, and unassigned
+// simple values) to different Go types, and compares results with simpleValueModel.
+func fuzzSimpleValue(data []byte, item *dataItem) {
+	// Reserved simple values 24..31 in two-byte encoding are malformed.
+	if len(data) >= 2 && data[0] == 0xf8 && data[1] < 32 {
+		for _, t := range simpleValueTypes {
+			v := reflect.New(t)
+			err := cbor.NewDecoder(bytes.NewReader(data)).Decode(v.Interface())
+			if _, ok := err.(*cbor.SyntaxError); !ok {
+				panic(fmt.Sprintf("decoding malformed simple value 0x%x to %s returned %v, want SyntaxError", data, t, err))
+			}
+		}
+		b, err := cbor.Marshal(cbor.SimpleValue(data[1]))
+		if data[1] < 24 && (err != nil || !bytes.Equal(b, []byte{0xe0 | data[1]})) {
+			panic(fmt.Sprintf("encoding SimpleValue(%d) returned 0x%x, %v", data[1], b, err))
+		}
+		if data[1] >= 24 && err == nil {
+			panic(fmt.Sprintf("encoding reserved SimpleValue(%d) returned 0x%x", data[1], b))
+		}
+		return
+	}
+
+	if item == nil || item.major != majorTypePrimitives || item.ai > 24 {
+		return
+	}
+
+	for _, t := range simpleValueTypes {
+		v := reflect.New(t)
+		err := cbor.NewDecoder(bytes.NewReader(data)).Decode(v.Interface())
+		want, ok := simpleValueModel(item.val, t)
+		if !ok {
+			if _, ok := err.(*cbor.UnmarshalTypeError); !ok {
+				panic(fmt.Sprintf("decoding simple value 0x%x to %s returned %v, want UnmarshalTypeError", data, t, err))
+			}
+			continue
+		}
+		if err != nil {
+			panic(fmt.Sprintf("decoding simple value 0x%x to %s returned %v", data, t, err))
+		}
+		if !DeepEqual(v.Elem().Interface(), want.Interface()) {
+			panic(fmt.Sprintf("decoding simple value 0x%x to %s returned %v, want %v", data, t, v.Elem(), want))
+		}
+	}
+
+	// Round trip simple value through interface{} and cbor.SimpleValue.
+	var iv interface{}
+	var sv cbor.SimpleValue
+	if err := cbor.Unmarshal(item.raw, &iv); err != nil {
+		panic(err)
+	}
+	if err := cbor.Unmarshal(item.raw, &sv); err != nil {
+		panic(err)
+	}
+	for _, em := range []cbor.EncMode{emDefault, emPreferred, emCanonical, emCoreDeterministic} {
+		b, err := em.Marshal(iv)
+		if err != nil {
+			panic(err)
+		}
+		want := item.raw
+		if item.val == 23 {
+			// Undefined is decoded to nil interface{}, which is encoded to null.
+			want = []byte{0xf6}
+		}
+		if !bytes.Equal(b, want) {
+			panic(fmt.Sprintf("simple value 0x%x round tripped through interface{} to 0x%x", item.raw, b))
+		}
+
+		b, err = em.Marshal(sv)
+		if err != nil {
+			panic(err)
+		}
+		if !bytes.Equal(b, item.raw) {
+			panic(fmt.Sprintf("simple value 0x%x round tripped through cbor.SimpleValue to 0x%x", item.raw, b))
+		}
+	}
+}
+
+// simpleValueModel returns Go value of type t that simple value sv is decoded to,
+// and false if decoding returns UnmarshalTypeError.
+func simpleValueModel(sv uint64, t reflect.Type) (reflect.Value, bool) {
+	v := reflect.New(t).Elem()
+	if t == typeSimpleValue {
+		v.SetUint(sv)
+		return v, true
+	}
+
+	switch sv {
+	case 20, 21:
+		switch t.Kind() {
+		case reflect.Interface:
+			v.Set(reflect.ValueOf(sv == 21))
+			return v, true
+		case reflect.Bool:
+			v.SetBool(sv == 21)
+			return v, true
+		}
+		return v, false
+	case 22, 23:
+		// Decoding null and undefined is no-op, except that slice, map, interface{},
+		// and pointer values are set to nil.
+		return v, true
+	}
+
+	// Unassigned simple values are decoded to CBOR simple value in interface{},
+	// and are decoded like unsigned integers otherwise.
+	switch t.Kind() {
+	case reflect.Interface:
+		v.Set(reflect.ValueOf(cbor.SimpleValue(sv)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if v.OverflowUint(sv) {
+			return v, false
+		}
+		v.SetUint(sv)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if v.OverflowInt(int64(sv)) {
+			return v, false
+		}
+		v.SetInt(int64(sv))
+	case reflect.Float32, reflect.Float64:
+		v.SetFloat(float64(sv))
+	default:
+		if t != typeBigInt {
+			return v, false
+		}
+		v.Set(reflect.ValueOf(*new(big.Int).SetUint64(sv)))
+	}
+	return v, true
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// checkSortedEncoding parses data encoded with sorted encoding mode em (Canonical, CTAP2, or
+// Core Deterministic), and checks that map keys are strictly ordered by less, heads are in
+// shortest form, floats have the width chosen by em's float options, and indefinite length
+// isn't used.  less is independent of em's sort mode, so sort mode set wrong in em is caught.
+func checkSortedEncoding(data []byte, mode string, em cbor.EncMode, less func(a, b []byte) bool) {
+	opts := em.EncOptions()
+
+	item, rest, err := parseDataItem(data)
+	if err != nil {
+		panic(fmt.Sprintf("%s encoding produced malformed CBOR data 0x%x: %v", mode, data, err))
+	}
+	if len(rest) > 0 {
+		panic(fmt.Sprintf("%s encoding produced extraneous data 0x%x", mode, data))
+	}
+
+	item.walk(func(it *dataItem) {
+		if it.indef {
+			panic(fmt.Sprintf("%s encoding produced indefinite length item 0x%x", mode, it.raw))
+		}
+
+		// Float width depends on value and float options instead of head argument.
+		if fv, ok := floatItemValue(it); ok {
+			if want := floatEncodingModel(fv, opts); !bytes.Equal(it.raw, want) {
+				panic(fmt.Sprintf("%s encoding produced float 0x%x, want 0x%x", mode, it.raw, want))
+			}
+		} else if it.headLen != len(appendHead(nil, it.major, it.val)) {
+			panic(fmt.Sprintf("%s encoding produced head not in shortest form 0x%x", mode, it.raw))
+		}
+
+		if it.major != majorTypeMap {
+			return
+		}
+		for i := 1; i < it.numPairs(); i++ {
+			k1, k2 := it.key(i-1), it.key(i)
+			if bytes.Equal(k1.raw, k2.raw) && isNaN(k1) {
+				// NaN != NaN, so Go map can hold several NaN keys that are encoded the same.
+				continue
+			}
+			if !less(k1.raw, k2.raw) {
+				panic(fmt.Sprintf("%s encoding produced map keys 0x%x and 0x%x not in strict order", mode, k1.raw, k2.raw))
+			}
+		}
+	})
+}
+
+// lengthFirstKeyLess returns true if encoded map key a sorts before b in RFC 7049 canonical
+// order: shorter key first, then lower byte value first.
+func lengthFirstKeyLess(a, b []byte) bool {
+	if len(a) != len(b) {
+		return len(a) < len(b)
+	}
+	return bytes.Compare(a, b) < 0
+}
+
+// bytewiseKeyLess returns true if encoded map key a sorts before b in RFC 8949 core
+// deterministic order: bytewise lexicographic order of encoded keys.
+func bytewiseKeyLess(a, b []byte) bool {
+	return bytes.Compare(a, b) < 0
+}
+
+// isNaN returns true if item is floating-point NaN.
+func isNaN(item *dataItem) bool {
+	f, ok := item.float()
+	return ok && math.IsNaN(f)
+}
+
+// floatItemValue returns value of floating-point item as float32 for float16 and float32
+// items, or float64 for float64 items, so floatEncodingModel sees the encoded width and bits.
+func floatItemValue(item *dataItem) (reflect.Value, bool) {
+	if item.major != majorTypePrimitives {
+		return reflect.Value{}, false
+	}
+	switch item.ai {
+	case 25:
+		return reflect.ValueOf(float16ToFloat32(uint16(item.val))), true
+	case 26:
+		return reflect.ValueOf(math.Float32frombits(uint32(item.val))), true
+	case 27:
+		return reflect.ValueOf(math.Float64frombits(item.val)), true
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// fieldInfo is a struct field that CBOR map keys can match.
+type fieldInfo struct {
+	idx       int // field index
+	name      string
+	keyAsInt  bool
+	nameAsInt int64
+}
+
+// structFields returns exported fields of struct type t, and true if t has toarray option.
+// Embedded structs aren't supported because structs in the harness don't have them.
+func structFields(t reflect.Type) (flds []fieldInfo, toArray bool) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Name == "_" {
+			toArray = strings.Contains(f.Tag.Get("cbor"), "toarray")
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+
+		tag := f.Tag.Get("cbor")
+		if tag == "" {
+			tag = f.Tag.Get("json")
+		}
+		if tag == "-" {
+			continue
+		}
+
+		opts := strings.Split(tag, ",")
+		fld := fieldInfo{idx: i, name: opts[0]}
+		if fld.name == "" {
+			fld.name = f.Name
+		}
+		for _, opt := range opts[1:] {
+			if opt == "keyasint" {
+				fld.keyAsInt = true
+				n, _ := strconv.Atoi(fld.name)
+				fld.nameAsInt = int64(n)
+			}
+		}
+		flds = append(flds, fld)
+	}
+	return flds, toArray
+}
+
+// matchStructFields returns index in flds of the field matched by each key in map item,
+// or -1 if key doesn't match any field.  A field is matched at most once, by the first key
+// matching its name exactly, or case-insensitively if no unmatched field has the exact name.
+// CBOR integer keys only match keyasint fields.
+func matchStructFields(flds []fieldInfo, item *dataItem) []int {
+	found := make([]bool, len(flds))
+	matches := make([]int, item.numPairs())
+	for j := range matches {
+		matches[j] = -1
+		k := item.key(j)
+		switch k.major {
+		case majorTypeTextString:
+			if !validTextString(k) {
+				continue
+			}
+			name := string(k.content)
+			for i, fld := range flds {
+				if !found[i] && fld.name == name {
+					matches[j] = i
+					break
+				}
+			}
+			if matches[j] == -1 {
+				for i, fld := range flds {
+					if !found[i] && len(fld.name) == len(name) && strings.EqualFold(fld.name, name) {
+						matches[j] = i
+						break
+					}
+				}
+			}
+		case majorTypePositiveInt, majorTypeNegativeInt:
+			if k.major == majorTypeNegativeInt && k.val > math.MaxInt64 {
+				continue
+			}
+			nameAsInt := int64(k.val)
+			if k.major == majorTypeNegativeInt {
+				nameAsInt = -1 ^ nameAsInt
+			}
+			for i, fld := range flds {
+				if !found[i] && fld.keyAsInt && fld.nameAsInt == nameAsInt {
+					matches[j] = i
+					break
+				}
+			}
+		}
+		if matches[j] >= 0 {
+			found[matches[j]] = true
+		}
+	}
+	return matches
+}
+
+// unknownField returns index of the first key in map item that doesn't match any field in flds,
+// and -1 if all keys match.  Keys that are invalid UTF-8 text strings or negative integers
+// overflowing int64 are skipped without matching.
+func unknownField(flds []fieldInfo, item *dataItem) int {
+	for j, i := range matchStructFields(flds, item) {
+		if i >= 0 {
+			continue
+		}
+		k := item.key(j)
+		if (k.major == majorTypeTextString && !validTextString(k)) ||
+			(k.major == majorTypeNegativeInt && k.val > math.MaxInt64) {
+			continue
+		}
+		return j
+	}
+	return -1
+}
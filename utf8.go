@@ -0,0 +1,152 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// maxFuzzTextStrings is the max number of CBOR text strings in data checked by fuzzUTF8.
+const maxFuzzTextStrings = 16
+
+var (
+	dmUTF8RejectInvalid, _ = cbor.DecOptions{UTF8: cbor.UTF8RejectInvalid}.DecMode()
+	dmUTF8DecodeInvalid, _ = cbor.DecOptions{UTF8: cbor.UTF8DecodeInvalid}.DecMode()
+)
+
+// fuzzUTF8 validates CBOR text strings in item independently, and checks decoding them
+// to string, []string, map[string]string, and interface{} with each UTF8 option.
+func fuzzUTF8(data []byte, item *dataItem) {
+	invalid := false
+	n := 0
+	item.walk(func(it *dataItem) {
+		if it.major != majorTypeTextString {
+			return
+		}
+		valid := validTextString(it)
+		if !valid {
+			invalid = true
+		}
+		if n >= maxFuzzTextStrings {
+			return
+		}
+		n++
+
+		s := string(it.content)
+		array := append(appendHead(nil, majorTypeArray, 1), it.raw...)
+		m := append(appendHead(nil, majorTypeMap, 1), it.raw...)
+		m = append(m, 0x60)
+
+		for _, form := range []struct {
+			data []byte
+			t    reflect.Type
+			want interface{}
+		}{
+			{it.raw, reflect.TypeOf(""), s},
+			{array, reflect.TypeOf([]string(nil)), []string{s}},
+			{m, reflect.TypeOf(map[string]string(nil)), map[string]string{s: ""}},
+			{it.raw, reflect.TypeOf((*interface{})(nil)).Elem(), s},
+		} {
+			for _, dm := range []cbor.DecMode{dmUTF8RejectInvalid, dmUTF8DecodeInvalid} {
+				v := reflect.New(form.t)
+				err := dm.NewDecoder(bytes.NewReader(form.data)).Decode(v.Interface())
+				if !valid && dm == dmUTF8RejectInvalid {
+					if _, ok := err.(*cbor.SemanticError); !ok {
+						panic(fmt.Sprintf("decoding invalid UTF-8 text string 0x%x to %s returned %v, want SemanticError", form.data, form.t, err))
+					}
+					continue
+				}
+				if err != nil {
+					panic(fmt.Sprintf("decoding text string 0x%x to %s returned %v", form.data, form.t, err))
+				}
+				// Invalid UTF-8 is decoded as is with UTF8DecodeInvalid.
+				if !reflect.DeepEqual(v.Elem().Interface(), form.want) {
+					panic(fmt.Sprintf("decoding text string 0x%x to %s returned %q, want %q", form.data, form.t, v.Elem(), form.want))
+				}
+			}
+		}
+	})
+
+	if invalid {
+		var v interface{}
+		if err := dmUTF8RejectInvalid.NewDecoder(bytes.NewReader(data)).Decode(&v); err == nil {
+			panic(fmt.Sprintf("decoded CBOR data 0x%x with invalid UTF-8 text string without error", data))
+		}
+	}
+}
+
+// checkEncodedUTF8 checks that data encoded by mode doesn't have invalid UTF-8 text strings.
+func checkEncodedUTF8(data []byte, mode string) {
+	item, _, err := parseDataItem(data)
+	if err != nil {
+		panic(fmt.Sprintf("%s encoding produced malformed CBOR data 0x%x: %v", mode, data, err))
+	}
+	item.walk(func(it *dataItem) {
+		if it.major == majorTypeTextString && !validTextString(it) {
+			panic(fmt.Sprintf("%s encoding produced invalid UTF-8 text string 0x%x", mode, it.raw))
+		}
+	})
+}
+
+// validTextString returns true if text string item is valid UTF-8.  Each chunk of
+// indefinite length text string must be valid UTF-8 by itself.
+func validTextString(item *dataItem) bool {
+	if item.indef {
+		for _, chunk := range item.chunks {
+			if !validUTF8(chunk.content) {
+				return false
+			}
+		}
+		return true
+	}
+	return validUTF8(item.content)
+}
+
+// validUTF8 returns true if b is well-formed UTF-8 defined in RFC 3629 section 4,
+// without surrogates and overlong encodings.
+func validUTF8(b []byte) bool {
+	for i := 0; i < len(b); {
+		c := b[i]
+		var n int
+		lo, hi := byte(0x80), byte(0xbf) // range of the second byte
+		switch {
+		case c < 0x80:
+			i++
+			continue
+		case c >= 0xc2 && c <= 0xdf:
+			n = 1
+		case c == 0xe0:
+			n, lo = 2, 0xa0
+		case c >= 0xe1 && c <= 0xec, c == 0xee, c == 0xef:
+			n = 2
+		case c == 0xed:
+			n, hi = 2, 0x9f
+		case c == 0xf0:
+			n, lo = 3, 0x90
+		case c >= 0xf1 && c <= 0xf3:
+			n = 3
+		case c == 0xf4:
+			n, hi = 3, 0x8f
+		default:
+			return false
+		}
+		if len(b)-i <= n {
+			return false
+		}
+		if b[i+1] < lo || b[i+1] > hi {
+			return false
+		}
+		for j := 2; j <= n; j++ {
+			if b[i+j] < 0x80 || b[i+j] > 0xbf {
+				return false
+			}
+		}
+		i += n + 1
+	}
+	return true
+}
@@ -0,0 +1,351 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+
+	cborv1 "github.com/fxamacker/cbor"
+	"github.com/fxamacker/cbor/v2"
+)
+
+var typeEmptyInterface = reflect.TypeOf((*interface{})(nil)).Elem()
+
+// v1BehaviorChanges are documented behavior changes from cbor v1 to cbor v2.  Decoding data
+// item to Go type t can be different between v1 and v2 if any of them applies to item or to
+// a nested item, and Go type it's decoded to.
+var v1BehaviorChanges = []struct {
+	name    string
+	applies func(item *dataItem, t reflect.Type) bool
+}{
+	{
+		"v2 decodes tag 0 and 1 to time.Time, and rejects invalid tag content",
+		func(item *dataItem, t reflect.Type) bool {
+			return isTagItem(item, 0, 1) && (t == typeEmptyInterface || t == typeTime || !validBuiltinTagContent(item))
+		},
+	},
+	{
+		"v2 decodes tag 2 and 3 to big.Int and Go integer and float types",
+		func(item *dataItem, t reflect.Type) bool {
+			return isTagItem(item, 2, 3) && (t == typeEmptyInterface || t == typeBigInt || isNumberKind(t.Kind()) || !validBuiltinTagContent(item))
+		},
+	},
+	{
+		"v2 keeps tag numbers in interface{}, cbor.Tag, and cbor.RawTag",
+		func(item *dataItem, t reflect.Type) bool {
+			return item.major == majorTypeTag && (t == typeEmptyInterface || t == typeTag || t == typeRawTag)
+		},
+	},
+	{
+		"v2 removes self-described CBOR tag before calling UnmarshalCBOR",
+		func(item *dataItem, t reflect.Type) bool {
+			return isTagItem(item, 55799) && reflect.PtrTo(t).Implements(typeUnmarshaler)
+		},
+	},
+	{
+		"cbor.Tag and cbor.RawTag are new in v2, and v1 decodes them as structs",
+		func(item *dataItem, t reflect.Type) bool {
+			return t == typeTag || t == typeRawTag
+		},
+	},
+	{
+		"v2 decodes CBOR integers, bignums, and simple values to big.Int, and v1 decodes big.Int as struct",
+		func(item *dataItem, t reflect.Type) bool {
+			if t != typeBigInt {
+				return false
+			}
+			switch item.major {
+			case majorTypePositiveInt, majorTypeNegativeInt, majorTypeTag:
+				return true
+			case majorTypePrimitives:
+				return item.ai < 20 || item.ai == 24
+			}
+			return false
+		},
+	},
+	{
+		"v2 decodes time.Time only from text string, integer, float, null, and undefined",
+		func(item *dataItem, t reflect.Type) bool {
+			if t != typeTime {
+				return false
+			}
+			switch item.major {
+			case majorTypeTextString, majorTypePositiveInt, majorTypeNegativeInt:
+				return false
+			case majorTypePrimitives:
+				return item.ai < 25 && !isNull(item)
+			}
+			return true
+		},
+	},
+	{
+		"v2 decodes negative integers overflowing int64 to big.Int in interface{}",
+		func(item *dataItem, t reflect.Type) bool {
+			return item.major == majorTypeNegativeInt && item.val > math.MaxInt64 && t == typeEmptyInterface
+		},
+	},
+	{
+		"v2 decodes byte string map keys to cbor.ByteString in interface{}",
+		func(item *dataItem, t reflect.Type) bool {
+			return hasByteStringKey(item) && (t == typeEmptyInterface || (t.Kind() == reflect.Map && t.Key() == typeEmptyInterface))
+		},
+	},
+	{
+		"v2 decodes byte strings to byte arrays",
+		func(item *dataItem, t reflect.Type) bool {
+			return item.major == majorTypeByteString && t.Kind() == reflect.Array && t.Elem().Kind() == reflect.Uint8
+		},
+	},
+	{
+		"v2 decodes simple values to cbor.SimpleValue in interface{}",
+		func(item *dataItem, t reflect.Type) bool {
+			return item.major == majorTypePrimitives && (item.ai < 20 || item.ai == 24) && t == typeEmptyInterface
+		},
+	},
+	{
+		"v2 rejects CBOR map for struct with toarray option",
+		func(item *dataItem, t reflect.Type) bool {
+			if item.major != majorTypeMap || t.Kind() != reflect.Struct {
+				return false
+			}
+			_, toArray := structFields(t)
+			return toArray
+		},
+	},
+}
+
+// FuzzV1 decodes data with cbor v1 and cbor v2 to each Go type created by ctors, and reports
+// differences in acceptance and decoded values that aren't documented behavior changes in
+// v1BehaviorChanges.  v2 Unmarshal rejects extraneous data after the first data item, so
+// data with extraneous data is decoded with UnmarshalFirst.
+func FuzzV1(data []byte) int {
+	item, rest, itemErr := parseDataItem(data)
+
+	score := 0
+	for _, ctor := range ctors {
+		v1, v2 := ctor(), ctor()
+		err1 := unmarshalV1(data, v1)
+
+		var err2 error
+		if itemErr == nil && len(rest) > 0 {
+			_, err2 = cbor.UnmarshalFirst(data, v2)
+		} else {
+			err2 = cbor.Unmarshal(data, v2)
+		}
+
+		t := reflect.TypeOf(v1)
+		if _, panicked := err1.(*v1PanicError); panicked {
+			if itemErr != nil || !v1IndefiniteArrayBug(item, t) {
+				panic(fmt.Sprintf("cbor v1 decoding 0x%x to %v panicked: %v", data, t, err1))
+			}
+			continue
+		}
+		if err1 == nil && err2 == nil {
+			score = 1
+			if roundTripEqual(v1, v2) {
+				continue
+			}
+		} else if (err1 == nil) == (err2 == nil) {
+			continue
+		}
+
+		if itemErr != nil {
+			panic(fmt.Sprintf("decoding malformed CBOR data 0x%x (%v) to %v: v1 returned %v, v2 returned %v", data, itemErr, t, err1, err2))
+		}
+		if v1BehaviorChange(item, t) != "" {
+			continue
+		}
+		panic(fmt.Sprintf("decoding 0x%x to %v: v1 returned %+v (%v), v2 returned %+v (%v)",
+			data, t, reflect.ValueOf(v1).Elem(), err1, reflect.ValueOf(v2).Elem(), err2))
+	}
+	return score
+}
+
+// v1PanicError is panic recovered from cbor v1.
+type v1PanicError struct {
+	v interface{}
+}
+
+func (e *v1PanicError) Error() string {
+	return fmt.Sprint(e.v)
+}
+
+// unmarshalV1 decodes data to v with cbor v1, and returns v1PanicError if v1 panicked.
+func unmarshalV1(data []byte, v interface{}) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = &v1PanicError{r}
+		}
+	}()
+	return cborv1.Unmarshal(data, v)
+}
+
+// v1BehaviorChange returns the first behavior change in v1BehaviorChanges that applies to
+// decoding item to Go type t, or "" if none applies.
+func v1BehaviorChange(item *dataItem, t reflect.Type) string {
+	for _, change := range v1BehaviorChanges {
+		if anyDecodedItem(item, t, change.applies) {
+			return change.name
+		}
+	}
+	return ""
+}
+
+// anyDecodedItem returns true if fn returns true for item and Go type t, or for any item
+// nested inside item and Go type it's decoded to.  Items decoded by UnmarshalCBOR and
+// library types are decoded by v2 or as a whole, so items nested inside them are skipped.
+func anyDecodedItem(item *dataItem, t reflect.Type, fn func(*dataItem, reflect.Type) bool) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if fn(item, t) {
+		return true
+	}
+	if reflect.PtrTo(t).Implements(typeUnmarshaler) || t == typeTag || t == typeRawTag || t == typeBigInt || t == typeTime {
+		return false
+	}
+
+	switch item.major {
+	case majorTypeTag:
+		return anyDecodedItem(item.tagContent(), t, fn)
+	case majorTypeArray:
+		switch t.Kind() {
+		case reflect.Interface, reflect.Slice, reflect.Array:
+			elem := t
+			if t.Kind() != reflect.Interface {
+				elem = t.Elem()
+			}
+			for _, it := range item.items {
+				if anyDecodedItem(it, elem, fn) {
+					return true
+				}
+			}
+		case reflect.Struct:
+			flds, toArray := structFields(t)
+			for i, it := range item.items {
+				if toArray && i < len(flds) && anyDecodedItem(it, t.Field(flds[i].idx).Type, fn) {
+					return true
+				}
+			}
+		}
+	case majorTypeMap:
+		switch t.Kind() {
+		case reflect.Interface, reflect.Map:
+			kt, et := t, t
+			if t.Kind() == reflect.Map {
+				kt, et = t.Key(), t.Elem()
+			}
+			for i := 0; i < item.numPairs(); i++ {
+				if anyDecodedItem(item.key(i), kt, fn) || anyDecodedItem(item.value(i), et, fn) {
+					return true
+				}
+			}
+		case reflect.Struct:
+			flds, _ := structFields(t)
+			for j, i := range matchStructFields(flds, item) {
+				if i >= 0 && anyDecodedItem(item.value(j), t.Field(flds[i].idx).Type, fn) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// v1IndefiniteArrayBug returns true if decoding item to Go type t can hit the cbor v1 bug
+// fixed in v2: v1 reads past the "break" code when indefinite length array is shorter than
+// Go array.
+func v1IndefiniteArrayBug(item *dataItem, t reflect.Type) bool {
+	indefArray := false
+	item.walk(func(it *dataItem) {
+		if it.major == majorTypeArray && it.indef {
+			indefArray = true
+		}
+	})
+	return indefArray && containsType(t, func(t reflect.Type) bool { return t.Kind() == reflect.Array })
+}
+
+// containsType returns true if fn returns true for t or any type nested inside t.
+func containsType(t reflect.Type, fn func(reflect.Type) bool) bool {
+	return containsTypeVisited(t, fn, make(map[reflect.Type]bool))
+}
+
+func containsTypeVisited(t reflect.Type, fn func(reflect.Type) bool, visited map[reflect.Type]bool) bool {
+	if visited[t] {
+		return false
+	}
+	visited[t] = true
+
+	if fn(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		return containsTypeVisited(t.Elem(), fn, visited)
+	case reflect.Map:
+		return containsTypeVisited(t.Key(), fn, visited) || containsTypeVisited(t.Elem(), fn, visited)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if containsTypeVisited(t.Field(i).Type, fn, visited) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isTagItem returns true if item is tag with any of tag numbers nums.
+func isTagItem(item *dataItem, nums ...uint64) bool {
+	if item.major != majorTypeTag {
+		return false
+	}
+	for _, num := range nums {
+		if item.val == num {
+			return true
+		}
+	}
+	return false
+}
+
+// validBuiltinTagContent returns true if content of tag 0, 1, 2, or 3 item has the major
+// type and float encoding required by v2.
+func validBuiltinTagContent(item *dataItem) bool {
+	content := item.tagContent()
+	switch item.val {
+	case 0:
+		return content.major == majorTypeTextString
+	case 1:
+		return content.major == majorTypePositiveInt || content.major == majorTypeNegativeInt ||
+			(content.major == majorTypePrimitives && content.ai >= 25 && content.ai <= 27)
+	case 2, 3:
+		return content.major == majorTypeByteString
+	}
+	return true
+}
+
+// isNumberKind returns true if k is Go integer or float kind.
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// hasByteStringKey returns true if item is map with byte string key.
+func hasByteStringKey(item *dataItem) bool {
+	if item.major != majorTypeMap {
+		return false
+	}
+	for i := 0; i < item.numPairs(); i++ {
+		if item.key(i).major == majorTypeByteString {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+// Copyright (c) 2019 Faye Amacker. All rights reserved.
+// Use of this source code is governed by a MIT license found in the LICENSE file.
+
+package cbor
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/fxamacker/cbor/v2"
+)
+
+// Authenticator data flags (WebAuthn section 6.1).
+const (
+	authnDataFlagAT = 0x40 // attested credential data included
+	authnDataFlagED = 0x80 // extension data included
+)
+
+// authenticatorData is WebAuthn authenticator data (WebAuthn section 6.1).
+type authenticatorData struct {
+	RPIDHash     [32]byte
+	Flags        byte
+	SignCount    uint32
+	AAGUID       [16]byte
+	CredentialID []byte
+	PublicKey    coseKey
+	Extensions   map[string]interface{}
+}
+
+// fuzzAuthenticatorData parses authenticator data in attestation object.  COSE_Key in
+// attested credential data is decoded with UnmarshalFirst, and remaining bytes must be
+// extension data if ED flag is set, or empty otherwise.
+func fuzzAuthenticatorData(data []byte) {
+	var ad authenticatorData
+	if len(data) < 37 {
+		return
+	}
+	copy(ad.RPIDHash[:], data)
+	ad.Flags = data[32]
+	ad.SignCount = binary.BigEndian.Uint32(data[33:])
+	rest := data[37:]
+
+	if ad.Flags&authnDataFlagAT != 0 {
+		if len(rest) < 18 {
+			return
+		}
+		copy(ad.AAGUID[:], rest)
+		n := int(binary.BigEndian.Uint16(rest[16:]))
+		rest = rest[18:]
+		if len(rest) < n {
+			return
+		}
+		ad.CredentialID, rest = rest[:n], rest[n:]
+
+		// COSE_Key is followed by extension data without length.
+		key := rest
+		var err error
+		if rest, err = unmarshalFirst(key, &ad.PublicKey); err != nil {
+			return
+		}
+		keyItem, _, err := parseDataItem(key)
+		if err != nil {
+			panic(fmt.Sprintf("UnmarshalFirst decoded malformed COSE_Key 0x%x: %v", key, err))
+		}
+		fuzzCOSEKey(keyItem.raw, keyItem, ad.PublicKey.Kty)
+
+		if ad.Flags&authnDataFlagED == 0 && len(rest) > 0 {
+			// COSE_Key is the last data item without extension data, so Unmarshal rejects
+			// authenticator data with remaining bytes.
+			var k coseKey
+			err := cbor.Unmarshal(key, &k)
+			if _, ok := err.(*cbor.ExtraneousDataError); !ok {
+				panic(fmt.Sprintf("decoding COSE_Key 0x%x with %d remaining bytes returned %v, want ExtraneousDataError", key, len(rest), err))
+			}
+		}
+	}
+
+	if ad.Flags&authnDataFlagED == 0 {
+		return
+	}
+	extRest, err := unmarshalFirst(rest, &ad.Extensions)
+	if err != nil {
+		return
+	}
+
+	// Unmarshal accepts extension data iff it's the last data item.
+	var ext map[string]interface{}
+	err = cbor.Unmarshal(rest, &ext)
+	if len(extRest) == 0 && err != nil {
+		panic(fmt.Sprintf("decoding extension data 0x%x returned %v", rest, err))
+	}
+	if len(extRest) > 0 {
+		if _, ok := err.(*cbor.ExtraneousDataError); !ok {
+			panic(fmt.Sprintf("decoding extension data 0x%x with %d remaining bytes returned %v, want ExtraneousDataError", rest, len(extRest), err))
+		}
+	}
+}
+
+// unmarshalFirst decodes the first data item in data to v with UnmarshalFirst, and checks
+// returned remaining bytes against parseDataItem.
+func unmarshalFirst(data []byte, v interface{}) ([]byte, error) {
+	rest, err := cbor.UnmarshalFirst(data, v)
+	_, wantRest, itemErr := parseDataItem(data)
+	if itemErr != nil {
+		if err == nil {
+			panic(fmt.Sprintf("UnmarshalFirst decoded malformed CBOR data 0x%x: %v", data, itemErr))
+		}
+		return nil, err
+	}
+	if err != nil {
+		if rest != nil {
+			panic(fmt.Sprintf("UnmarshalFirst returned remaining bytes 0x%x with error %v", rest, err))
+		}
+		return nil, err
+	}
+	if !bytes.Equal(rest, wantRest) {
+		panic(fmt.Sprintf("UnmarshalFirst 0x%x returned remaining bytes 0x%x, want 0x%x", data, rest, wantRest))
+	}
+	return rest, nil
+}